@@ -0,0 +1,161 @@
+package yaorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// countColumnPrefix prefixes the name under which the number of related rows of a relation is selected
+const countColumnPrefix = "yaorm_count"
+
+// countRelation is a relation whose rows are counted, declared on the model with `count:"table,fk"`,
+// or `count:"table,fk,field"` when the fk does not hold the primary key of the model
+type countRelation struct {
+	name string
+	// index of the field of the model receiving the count
+	fieldIndex int
+	table      *Table
+	fk         string
+	field      string
+}
+
+// getCountRelation returns the relation of the model declared with a count tag for the provided table
+func getCountRelation(dbp DBProvider, table *Table, name string) (countRelation, error) {
+	st := table.reflectedType
+	for i := 0; i < st.NumField(); i++ {
+		tag, ok := st.Field(i).Tag.Lookup("count")
+		if !ok || tag == "-" {
+			continue
+		}
+		tagData := strings.Split(tag, ",")
+		if tagData[0] != name {
+			continue
+		}
+		if len(tagData) != 2 && len(tagData) != 3 {
+			return countRelation{}, errors.Errorf("tag 'count' %+v is invalid, must have 2 or 3 parts", tagData)
+		}
+		switch st.Field(i).Type.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		default:
+			return countRelation{}, errors.Errorf("Count field %s of model %s must be an integer", st.Field(i).Name, table.Name())
+		}
+		related, err := GetTable(dbp.dbName(), name)
+		if err != nil {
+			return countRelation{}, err
+		}
+		if related.FieldIndex(tagData[1]) < 0 {
+			return countRelation{}, errors.Errorf("Cannot find field %s in table %s", tagData[1], name)
+		}
+		r := countRelation{name: name, fieldIndex: i, table: related, fk: tagData[1]}
+		if len(tagData) == 3 {
+			r.field = tagData[2]
+		} else if len(table.Keys()) == 1 {
+			r.field = table.Keys()[0]
+		} else {
+			return countRelation{}, errors.Errorf("Table %s must have a single primary key to count %s, or declare the referenced field", table.Name(), name)
+		}
+		return r, nil
+	}
+	return countRelation{}, errors.Errorf("Cannot find tag count:%v in model %s", name, table.Name())
+}
+
+// expression returns the correlated subquery counting the related rows of the model selected under the alias
+func (r countRelation) expression(dbp DBProvider, alias string) string {
+	countAlias := dbp.EscapeValue(fmt.Sprintf("%s_%s", countColumnPrefix, r.name))
+	condition := fmt.Sprintf(
+		"%s.%s = %s.%s", countAlias, dbp.EscapeValue(r.fk), dbp.EscapeValue(alias), dbp.EscapeValue(r.field),
+	)
+	if r.table.SoftDeleteField() != "" {
+		condition = fmt.Sprintf("%s AND %s.%s IS NULL", condition, countAlias, dbp.EscapeValue(r.table.SoftDeleteField()))
+	}
+	return fmt.Sprintf("(SELECT COUNT(*) FROM %s AS %s WHERE %s)", r.table.NameForQuery(dbp), countAlias, condition)
+}
+
+// checkCounts checks the relations counted by the filter to filter or order the rows can be counted,
+// and that the filters on their counts can apply on an expression
+func checkCounts(dbp DBProvider, table *Table, f yaormfilter.Filter) error {
+	for _, countFilter := range getCountFilters(f) {
+		if _, ok := countFilter.Filter.(yaormfilter.ExpressionFilter); !ok {
+			return errors.Errorf("Filter %T on count %s cannot apply on an expression", countFilter.Filter, countFilter.Relation)
+		}
+		if _, err := getCountRelation(dbp, table, countFilter.Relation); err != nil {
+			return err
+		}
+	}
+	for _, orderBy := range f.GetOrderBy() {
+		if !orderBy.Count {
+			continue
+		}
+		if _, err := getCountRelation(dbp, table, orderBy.Field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectCountColumns adds the counts requested by the filter to the statement,
+// after checking the filter with checkFilter
+func selectCountColumns(dbp DBProvider, statement squirrel.SelectBuilder, table *Table, f yaormfilter.Filter) (squirrel.SelectBuilder, error) {
+	if err := checkFilter(dbp, table, f); err != nil {
+		return statement, err
+	}
+	for _, name := range getCounts(f) {
+		r, err := getCountRelation(dbp, table, name)
+		if err != nil {
+			return statement, err
+		}
+		statement = statement.Column(fmt.Sprintf(
+			"%s AS %s", r.expression(dbp, table.Name()), dbp.EscapeValue(countColumnPrefix+joinloadSeparator+name),
+		))
+	}
+	return statement, nil
+}
+
+// hasCounts returns true if the rows selected with the filter hold counts, and must be scanned with a rowScanner
+func hasCounts(f yaormfilter.Filter) bool {
+	return len(getCounts(f)) > 0
+}
+
+// getCounts returns the relations counted by the filter, if it implements yaormfilter.CountFilterer
+func getCounts(f yaormfilter.Filter) []string {
+	if counter, ok := f.(yaormfilter.CountFilterer); ok {
+		return counter.GetCounts()
+	}
+	return nil
+}
+
+// getCountFilters returns the filters on the counts of relations, if the filter implements yaormfilter.CountFilterer
+func getCountFilters(f yaormfilter.Filter) []*yaormfilter.CountFilter {
+	if counter, ok := f.(yaormfilter.CountFilterer); ok {
+		return counter.GetCountFilters()
+	}
+	return nil
+}
+
+// applyCountFilters filters the statement on the counts of relations requested by the filter
+// the counts must have been checked with checkCounts, it panics otherwise
+func applyCountFilters(statement squirrel.SelectBuilder, dbp DBProvider, table *Table, alias string, f yaormfilter.Filter) squirrel.SelectBuilder {
+	for _, countFilter := range getCountFilters(f) {
+		expressionFilter, ok := countFilter.Filter.(yaormfilter.ExpressionFilter)
+		if !ok {
+			panic(errors.Errorf("Filter %T on count %s cannot apply on an expression", countFilter.Filter, countFilter.Relation))
+		}
+		statement = expressionFilter.ApplyOnExpression(statement, countExpression(dbp, table, alias, countFilter.Relation))
+	}
+	return statement
+}
+
+// countExpression returns the expression counting the rows of the relation
+// the relation must have been checked with checkCounts, it panics otherwise
+func countExpression(dbp DBProvider, table *Table, alias, relation string) string {
+	r, err := getCountRelation(dbp, table, relation)
+	if err != nil {
+		panic(err)
+	}
+	return r.expression(dbp, alias)
+}
@@ -0,0 +1,102 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericSelectAll_WithCount(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	counts := map[string]int{"none": 0, "two": 2, "three": 3}
+	for _, name := range []string{"none", "two", "three"} {
+		category := &testdata.Category{Name: name}
+		saveModel(t, dbp, category)
+		for i := 0; i < counts[name]; i++ {
+			saveModel(t, dbp, &testdata.Post{Subject: "subject", CategoryID: category.ID})
+		}
+	}
+
+	f := testdata.NewCategoryFilter()
+	f.WithCount("post")
+	f.OrderByCount("post", yaormfilter.OrderingWays.Desc)
+	models, err := yaorm.GenericSelectAll(dbp, f)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 3) {
+		for i, name := range []string{"three", "two", "none"} {
+			category := models[i].(*testdata.Category)
+			assert.Equal(t, name, category.Name)
+			assert.Equal(t, int64(counts[name]), category.PostCount)
+			assert.Equal(t, dbp, category.GetDBP())
+		}
+	}
+
+	f = testdata.NewCategoryFilter()
+	f.WithCount("post")
+	f.FilterCount("post", yaormfilter.Gte(int64(2)))
+	f.OrderBy("name", yaormfilter.OrderingWays.Asc)
+	models, err = yaorm.GenericSelectAll(dbp, f)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 2) {
+		assert.Equal(t, "three", models[0].(*testdata.Category).Name)
+		assert.Equal(t, "two", models[1].(*testdata.Category).Name)
+	}
+
+	f = testdata.NewCategoryFilter().Name(yaormfilter.Equals("two"))
+	f.WithCount("post")
+	m, err := yaorm.GenericSelectOne(dbp, f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), m.(*testdata.Category).PostCount)
+
+	f = testdata.NewCategoryFilter()
+	f.FilterCount("post", yaormfilter.Equals(int64(0)))
+	count, err := yaorm.GenericCount(dbp, f)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	f = testdata.NewCategoryFilter()
+	f.WithCount("comment")
+	_, err = yaorm.GenericSelectAll(dbp, f)
+	assert.EqualError(t, err, "Cannot find tag count:comment in model category")
+
+	// invalid counts filtering or ordering the rows are reported instead of panicking
+	f = testdata.NewCategoryFilter()
+	f.FilterCount("comment", yaormfilter.Equals(int64(0)))
+	_, err = yaorm.GenericCount(dbp, f)
+	assert.EqualError(t, err, "Cannot find tag count:comment in model category")
+	_, err = yaorm.GenericSelectAll(dbp, f)
+	assert.EqualError(t, err, "Cannot find tag count:comment in model category")
+	f = testdata.NewCategoryFilter()
+	f.OrderByCount("comment", yaormfilter.OrderingWays.Desc)
+	_, err = yaorm.GenericExists(dbp, f)
+	assert.EqualError(t, err, "Cannot find tag count:comment in model category")
+	f = testdata.NewCategoryFilter()
+	f.FilterCount("post", columnOnlyFilter{yaormfilter.Equals(int64(0))})
+	_, err = yaorm.GenericCount(dbp, f)
+	assert.EqualError(t, err, "Filter yaorm_test.columnOnlyFilter on count post cannot apply on an expression")
+}
+
+// columnOnlyFilter is a value filter which can only apply on a column
+type columnOnlyFilter struct {
+	yaormfilter.ValueFilter
+}
+
+// filterWithoutCounts only implements yaormfilter.Filter, hiding the optional interfaces of the filter it wraps
+type filterWithoutCounts struct {
+	yaormfilter.Filter
+}
+
+func TestHasCounts_OptionalInterface(t *testing.T) {
+	f := testdata.NewCategoryFilter()
+	f.WithCount("post")
+	assert.True(t, yaorm.HasCounts(f))
+	assert.False(t, yaorm.HasCounts(filterWithoutCounts{Filter: f}))
+}
@@ -6,21 +6,93 @@ type DatabaseCapacity int8
 const (
 	DatabaseCapacitySchema = iota ^ 42
 	DatabaseCapacityUUID
+	DatabaseCapacityReturning
+	DatabaseCapacityWindowFunctions
+	DatabaseCapacityRecursiveQueries
+	DatabaseCapacityRightJoin
+	DatabaseCapacityFullJoin
+	DatabaseCapacitySelectForUpdate
+	DatabaseCapacitySelectForShare
+	DatabaseCapacityNoWait
+	DatabaseCapacitySkipLocked
 )
 
 var (
 	databaseCapacities = map[DMS]map[DatabaseCapacity]bool{
 		DatabaseMySQL: {
-			DatabaseCapacitySchema: true,
-			DatabaseCapacityUUID:   false,
+			DatabaseCapacitySchema:           true,
+			DatabaseCapacityUUID:             false,
+			DatabaseCapacityReturning:        false,
+			DatabaseCapacityWindowFunctions:  true,
+			DatabaseCapacityRecursiveQueries: true,
+			DatabaseCapacityRightJoin:        true,
+			DatabaseCapacityFullJoin:         false,
+			DatabaseCapacitySelectForUpdate:  true,
+			DatabaseCapacitySelectForShare:   true,
+			DatabaseCapacityNoWait:           true,
+			DatabaseCapacitySkipLocked:       true,
 		},
 		DatabasePostgreSQL: {
-			DatabaseCapacitySchema: true,
-			DatabaseCapacityUUID:   true,
+			DatabaseCapacitySchema:           true,
+			DatabaseCapacityUUID:             true,
+			DatabaseCapacityReturning:        true,
+			DatabaseCapacityWindowFunctions:  true,
+			DatabaseCapacityRecursiveQueries: true,
+			DatabaseCapacityRightJoin:        true,
+			DatabaseCapacityFullJoin:         true,
+			DatabaseCapacitySelectForUpdate:  true,
+			DatabaseCapacitySelectForShare:   true,
+			DatabaseCapacityNoWait:           true,
+			DatabaseCapacitySkipLocked:       true,
 		},
 		DatabaseSqlite3: {
-			DatabaseCapacitySchema: false,
-			DatabaseCapacityUUID:   false,
+			DatabaseCapacitySchema:           false,
+			DatabaseCapacityUUID:             false,
+			DatabaseCapacityReturning:        true,
+			DatabaseCapacityWindowFunctions:  true,
+			DatabaseCapacityRecursiveQueries: true,
+			DatabaseCapacityRightJoin:        true,
+			DatabaseCapacityFullJoin:         true,
+			DatabaseCapacitySelectForUpdate:  false,
+			DatabaseCapacitySelectForShare:   false,
+			DatabaseCapacityNoWait:           false,
+			DatabaseCapacitySkipLocked:       false,
+		},
+	}
+	// databaseCapacitiesMinVersion holds the minimal server version providing a capacity,
+	// when older versions of the database system do not provide it
+	databaseCapacitiesMinVersion = map[DMS]map[DatabaseCapacity][]int{
+		DatabaseMySQL: {
+			DatabaseCapacityWindowFunctions:  {8},
+			DatabaseCapacityRecursiveQueries: {8},
+			// locking a table with OF, FOR SHARE, NOWAIT and SKIP LOCKED came with MySQL 8
+			DatabaseCapacitySelectForUpdate: {8},
+			DatabaseCapacitySelectForShare:  {8},
+			DatabaseCapacityNoWait:          {8},
+			DatabaseCapacitySkipLocked:      {8},
+		},
+		DatabasePostgreSQL: {
+			DatabaseCapacitySkipLocked: {9, 5},
+		},
+		DatabaseSqlite3: {
+			DatabaseCapacityReturning:        {3, 35},
+			DatabaseCapacityWindowFunctions:  {3, 25},
+			DatabaseCapacityRecursiveQueries: {3, 8, 3},
+			DatabaseCapacityRightJoin:        {3, 39},
+			DatabaseCapacityFullJoin:         {3, 39},
 		},
 	}
 )
+
+// versionAtLeast returns true if version is greater than or equal to minVersion
+func versionAtLeast(version []int, minVersion []int) bool {
+	for i, min := range minVersion {
+		if i >= len(version) {
+			return false
+		}
+		if version[i] != min {
+			return version[i] > min
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package yaorm
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,10 +25,15 @@ type DB interface {
 
 type db struct {
 	zesty.DB
-	dbmap        *gorp.DbMap
-	system       DMS
-	executorHook ExecutorHook
-	dbSpecific   DBSpecific
+	dbmap                   *gorp.DbMap
+	system                  DMS
+	executorHook            ExecutorHook
+	dbSpecific              DBSpecific
+	timestampsInUTC         bool
+	subqueryloadConcurrency int
+	transactionRetry        *RetryPolicy
+	versionLock             sync.Mutex
+	version                 []int
 }
 
 func (d *db) System() DMS {
@@ -50,6 +56,33 @@ var (
 	registry            = map[string]DB{}
 )
 
+// parseVersion extracts the numeric parts of a version string, such as "8.0.23-log" or "12.4 (Debian)"
+func parseVersion(version string) []int {
+	parts := []int{}
+	current := -1
+	for _, c := range version {
+		switch {
+		case c >= '0' && c <= '9':
+			if current < 0 {
+				current = 0
+			}
+			current = current*10 + int(c-'0')
+		case c == '.' && current >= 0:
+			parts = append(parts, current)
+			current = -1
+		default:
+			if current >= 0 {
+				parts = append(parts, current)
+			}
+			return parts
+		}
+	}
+	if current >= 0 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
 // DatabaseConfiguration configures a database
 type DatabaseConfiguration struct {
 	Name             string
@@ -65,6 +98,14 @@ type DatabaseConfiguration struct {
 	// ExecutorHook is a configurable hook to add logs, for example, to your sql requests
 	ExecutorHook ExecutorHook
 	DBSpecific   DBSpecific
+	// TimestampsInUTC normalizes to UTC the time returned by DBProvider.Now, used for the automatic timestamps
+	TimestampsInUTC bool
+	// SubqueryloadConcurrency is the number of relations, or chunks of a relation, subqueryloaded in parallel
+	// outside transactions. Leave empty to subqueryload them one after another
+	SubqueryloadConcurrency int
+	// TransactionRetry is the retry policy of the transactions run by DBProvider.RunInTransaction, and by
+	// DBProvider.RunInTransactionWithOptions when their options do not provide one. Leave empty to never run them again
+	TransactionRetry *RetryPolicy
 }
 
 // GetDB returns a database object from its name
@@ -108,20 +149,63 @@ func RegisterDB(config *DatabaseConfiguration) error {
 		return err
 	}
 
+	dbmap := getDbMap(dbHandler)
+	configureTableMaps(config.Name, dbmap)
+
 	if config.DBSpecific == nil {
 		config.DBSpecific = noopSpecific{}
 	}
 
 	registry[config.Name] = &db{
-		DB:           dbHandler,
-		system:       config.System,
-		executorHook: config.ExecutorHook,
-		dbSpecific:   config.DBSpecific,
+		DB:                      dbHandler,
+		dbmap:                   dbmap,
+		system:                  config.System,
+		executorHook:            config.ExecutorHook,
+		dbSpecific:              config.DBSpecific,
+		timestampsInUTC:         config.TimestampsInUTC,
+		subqueryloadConcurrency: config.SubqueryloadConcurrency,
+		transactionRetry:        config.TransactionRetry,
 	}
 
 	return nil
 }
 
+func getDbMap(dbHandler zesty.DB) *gorp.DbMap {
+	v := tools.GetNonPtrValue(dbHandler)
+	return v.FieldByName("DbMap").Interface().(*gorp.DbMap)
+}
+
+// configureTableMaps forwards to gorp the yaorm options of the tables registered for this database
+func configureTableMaps(dbName string, dbmap *gorp.DbMap) {
+	tableMutex.RLock()
+	defer tableMutex.RUnlock()
+	for _, table := range tables[dbName] {
+		configureTableMap(dbmap, table)
+	}
+}
+
+// configureTableMap forwards to gorp the yaorm options of the table, adding the table to gorp
+// when it is declared after its database is registered
+func configureTableMap(dbmap *gorp.DbMap, table *Table) {
+	tm, err := dbmap.TableFor(table.reflectedType, false)
+	if err != nil {
+		tm = dbmap.AddTableWithName(tools.GetNonPtrInterface(table.model), table.name)
+	}
+	// using gorp dialect to know if the driver supports schemas, like rekordo does
+	if table.schema != "" && strings.Contains(dbmap.Dialect.QuotedTableForQuery("schema", "table"), "schema") {
+		tm.SchemaName = table.schema
+	}
+	// the keys may be set before the auto increment is disabled, gorp only auto increments a single key
+	tm.SetKeys(table.tm.AutoIncrement && len(table.tm.Keys) == 1, table.tm.Keys...)
+	if table.versionField != "" {
+		tm.SetVersionCol(table.versionField)
+	}
+	// readonly columns already exist at this point, they are only skipped from now on
+	for _, field := range table.readonlyFields {
+		tm.ColMap(field).SetTransient(true)
+	}
+}
+
 // UnregisterDB removes the database from the registry
 func UnregisterDB(name string) error {
 	dblock.Lock()
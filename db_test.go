@@ -2,6 +2,7 @@ package yaorm_test
 
 import (
 	"context"
+	stderrors "errors"
 	"os"
 	"testing"
 
@@ -86,6 +87,56 @@ func TestDb_System(t *testing.T) {
 	assert.Equal(t, yaorm.DatabaseSqlite3, db.System())
 }
 
+type lateNote struct {
+	yaorm.DatabaseModel
+	ID        int64  `db:"id"`
+	Content   string `db:"content"`
+	Reference string `db:"reference" yaorm:"readonly"`
+	Version   int64  `db:"version" yaorm:"version"`
+}
+
+func TestDb_TableDeclaredAfterRegistration(t *testing.T) {
+	defer func() {
+		os.Remove("/tmp/test_late.sqlite")
+		yaorm.UnregisterDB("late")
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:   "late",
+		DSN:    "/tmp/test_late.sqlite",
+		System: yaorm.DatabaseSqlite3,
+	})
+	assert.Nil(t, err)
+	yaorm.NewTable("late", "late_note", &lateNote{})
+
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "late")
+	assert.Nil(t, err)
+	_, err = dbp.DB().Exec(`CREATE TABLE late_note (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content TEXT,
+		reference TEXT NOT NULL DEFAULT 'generated',
+		version INTEGER NOT NULL DEFAULT 0
+	)`)
+	assert.Nil(t, err)
+
+	note := &lateNote{Content: "content", Reference: "ignored"}
+	note.SetDBP(dbp)
+	err = yaorm.GenericInsert(note)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), note.Version)
+	reference, err := dbp.DB().SelectStr("SELECT reference FROM late_note WHERE id = ?", note.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "generated", reference)
+
+	stale := &lateNote{ID: note.ID, Content: note.Content, Version: note.Version}
+	stale.SetDBP(dbp)
+	note.Content = "first edition"
+	err = yaorm.GenericUpdate(note)
+	assert.Nil(t, err)
+	stale.Content = "second edition"
+	err = yaorm.GenericUpdate(stale)
+	assert.True(t, stderrors.Is(err, yaorm.ErrStaleObject))
+}
+
 func TestDb_ExecutorHook(t *testing.T) {
 	defer func() {
 		os.Remove("/tmp/test_test.sqlite")
@@ -2,12 +2,17 @@ package yaorm
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/loopfz/gadgeto/zesty"
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/satori/go.uuid"
 	"github.com/geoffreybauduin/yaorm/tools"
 	"github.com/go-gorp/gorp"
+	"github.com/juju/errors"
 )
 
 // DBProvider provides an abstracted way of accessing the database
@@ -21,39 +26,94 @@ type DBProvider interface {
 	getDialect() gorp.Dialect
 	HasCapacity(capacity DatabaseCapacity) bool
 	RunInTransaction(func() error) error
+	RunInTransactionWithOptions(opts TxOptions, fn func() error) error
+	Now() time.Time
+	SetClock(clock Clock)
+	OnCommit(fn func())
+	OnRollback(fn func())
+	dbName() string
+	beginLoadingRelations() func() []interface{}
+	loadingRelations() *relationsLoading
+	afterLoad(m interface{}) error
+}
+
+// TxOptions holds the characteristics of a transaction started by RunInTransactionWithOptions
+type TxOptions struct {
+	// Isolation is the isolation level of the transaction, sql.LevelDefault keeping the one of the database
+	Isolation sql.IsolationLevel
+	// ReadOnly forbids the transaction to write
+	ReadOnly bool
+	// Deferrable lets a serializable read only transaction wait for a snapshot which cannot fail
+	// with a serialization error (PostgreSQL only, ignored by other databases)
+	Deferrable bool
+	// Retry runs the transaction again when it fails with a transient error, nil using the TransactionRetry policy
+	// of the database, and an empty policy never running it again
+	Retry *RetryPolicy
+}
+
+// isDefault returns true if the options keep the characteristics of a default transaction
+func (o TxOptions) isDefault() bool {
+	return o.Isolation == sql.LevelDefault && !o.ReadOnly && !o.Deferrable
+}
+
+// Clock returns the current time
+type Clock func() time.Time
+
+// callbacksMark is the number of commit and rollback callbacks registered when a nested transaction began
+type callbacksMark struct {
+	onCommit   int
+	onRollback int
 }
 
 type dbprovider struct {
 	zesty.DBProvider
-	name string
-	ctx  context.Context
-	uuid string
+	name  string
+	ctx   context.Context
+	uuid  string
+	clock Clock
+	// txOptions holds the options of the transaction begun by RunInTransactionWithOptions
+	txOptions TxOptions
+	// nested holds the callbacks marks of the transactions nested in the current one, the innermost last
+	nested []callbacksMark
+	// onCommit and onRollback hold the callbacks registered in the current transaction
+	onCommit   []func()
+	onRollback []func()
+	// loading is the state of the select loading its relations, shared by the selects loading them
+	loading     *relationsLoading
+	loadingLock sync.Mutex
 }
 
 // NewDBProvider creates a new db provider
 func NewDBProvider(ctx context.Context, name string) (DBProvider, error) {
 	dblock.RLock()
 	defer dblock.RUnlock()
-	zestyDbp, err := zesty.NewDBProvider(name)
-	if err != nil {
+	if _, err := GetDB(name); err != nil {
 		return nil, err
 	}
 	uuid4 := uuid.NewV4()
-	if err != nil {
-		return nil, err
-	}
-	dbp := &dbprovider{DBProvider: zestyDbp, name: name, ctx: ctx, uuid: uuid4.String()}
+	dbp := &dbprovider{name: name, ctx: ctx, uuid: uuid4.String()}
+	dbp.resetTx()
 	return dbp, dbp.getDb().DBSpecific().OnSessionCreated(dbp)
 }
 
 // DB returns a SQL Executor interface
+// while relations are loaded in parallel, the queries run with the context cancelled when one of them fails
 func (dbp *dbprovider) DB() gorp.SqlExecutor {
 	dbRetrieved := dbp.getDb()
 	dbUsed := dbp.DBProvider.DB()
+	ctx := dbp.Context()
+	if loading := dbp.loadingRelations(); loading != nil && loading.slots != nil {
+		if d, ok := dbRetrieved.(*db); ok {
+			if _, ok := dbUsed.(transactionExecutor); !ok {
+				ctx = loading.ctx
+				dbUsed = d.dbmap.WithContext(ctx)
+			}
+		}
+	}
 	return &SqlExecutor{
 		SqlExecutor: dbUsed,
 		db:          dbRetrieved,
-		ctx:         dbp.Context(),
+		ctx:         ctx,
 		dbp:         dbp,
 	}
 }
@@ -65,12 +125,11 @@ func (dbp *dbprovider) EscapeValue(value string) string {
 
 // CanSelectForUpdate returns true if the current dialect can perform select for update statements
 func (dbp *dbprovider) CanSelectForUpdate() bool {
-	db := registry[dbp.name]
-	switch db.System() {
-	case DatabasePostgreSQL:
-		return true
-	}
-	return false
+	return dbp.HasCapacity(DatabaseCapacitySelectForUpdate)
+}
+
+func (dbp *dbprovider) dbName() string {
+	return dbp.name
 }
 
 func (dbp *dbprovider) getDb() DB {
@@ -109,16 +168,180 @@ func (dbp *dbprovider) HasCapacity(capacity DatabaseCapacity) bool {
 	if _, ok := databaseCapacities[system]; !ok {
 		return false
 	}
-	return databaseCapacities[system][capacity]
+	if !databaseCapacities[system][capacity] {
+		return false
+	}
+	if minVersion, ok := databaseCapacitiesMinVersion[system][capacity]; ok {
+		return versionAtLeast(dbp.serverVersion(), minVersion)
+	}
+	return true
+}
+
+// serverVersion returns the version of the database server, retrieved once per database
+// it is queried outside of the transaction of the DBProvider, so that a failure does not abort it,
+// and queried again by the next call when it could not be retrieved
+func (dbp *dbprovider) serverVersion() []int {
+	d, ok := dbp.getDb().(*db)
+	if !ok {
+		return nil
+	}
+	d.versionLock.Lock()
+	defer d.versionLock.Unlock()
+	if d.version != nil {
+		return d.version
+	}
+	var query string
+	switch d.System() {
+	case DatabasePostgreSQL:
+		query = "SHOW server_version"
+	case DatabaseMySQL:
+		query = "SELECT VERSION()"
+	case DatabaseSqlite3:
+		query = "SELECT sqlite_version()"
+	}
+	version, err := d.dbmap.WithContext(dbp.Context()).SelectStr(query)
+	if err != nil {
+		return nil
+	}
+	d.version = parseVersion(version)
+	return d.version
+}
+
+// Now returns the current time according to the clock of this DBProvider,
+// normalized to UTC if the database is configured this way
+func (dbp *dbprovider) Now() time.Time {
+	now := time.Now()
+	if dbp.clock != nil {
+		now = dbp.clock()
+	}
+	if d, ok := dbp.getDb().(*db); ok && d.timestampsInUTC {
+		now = now.UTC()
+	}
+	return now
+}
+
+// SetClock replaces the clock used by Now, a nil clock restores the system one
+func (dbp *dbprovider) SetClock(clock Clock) {
+	dbp.clock = clock
+}
+
+// beginLoadingRelations starts loading the relations of the selected models, until the returned function is called
+// the DBHookAfterLoad hooks of the models loaded meanwhile are held, and returned once the outermost select
+// has loaded its whole relation tree, nil being returned otherwise
+func (dbp *dbprovider) beginLoadingRelations() func() []interface{} {
+	concurrency := 0
+	if d, ok := dbp.getDb().(*db); ok && !inTransaction(dbp) {
+		concurrency = d.subqueryloadConcurrency
+	}
+	dbp.loadingLock.Lock()
+	defer dbp.loadingLock.Unlock()
+	if dbp.loading == nil {
+		dbp.loading = newRelationsLoading(dbp.Context(), concurrency)
+	}
+	dbp.loading.depth++
+	return func() []interface{} {
+		dbp.loadingLock.Lock()
+		defer dbp.loadingLock.Unlock()
+		loading := dbp.loading
+		loading.depth--
+		if loading.depth > 0 {
+			return nil
+		}
+		dbp.loading = nil
+		loading.close()
+		return loading.afterLoads
+	}
+}
+
+// loadingRelations returns the state of the select loading its relations, nil when no relation is being loaded
+func (dbp *dbprovider) loadingRelations() *relationsLoading {
+	dbp.loadingLock.Lock()
+	defer dbp.loadingLock.Unlock()
+	return dbp.loading
+}
+
+// afterLoad calls the DBHookAfterLoad hook of the model(s), or holds it while relations are being loaded
+func (dbp *dbprovider) afterLoad(m interface{}) error {
+	dbp.loadingLock.Lock()
+	if dbp.loading != nil {
+		dbp.loading.afterLoads = append(dbp.loading.afterLoads, m)
+		dbp.loadingLock.Unlock()
+		return nil
+	}
+	dbp.loadingLock.Unlock()
+	return callAfterLoadHooks(m)
+}
+
+// inTransaction returns whether the statements of the DBProvider run inside a transaction
+func inTransaction(dbp DBProvider) bool {
+	executor, err := sqlExecutor(dbp)
+	if err != nil {
+		return false
+	}
+	_, ok := executor.SqlExecutor.(transactionExecutor)
+	return ok
+}
+
+// transactionExecutor is the executor of a transaction, either begun by gorp or begun with options
+type transactionExecutor interface {
+	gorp.SqlExecutor
+	ReleaseSavepoint(savepoint string) error
 }
 
 // RunInTraction will run the provided function inside a transaction.
 // if an error occurs, the transaction is automatically rolled back.
 // at the end of the transaction, the transaction is commit inside the
-// dbms
+// dbms, and run again as required by the TransactionRetry policy of the database
+// When called inside another transaction, the function runs inside a nested transaction instead (see Tx),
+// rolled back when the function fails, the outer transaction being left intact
 func (dbp *dbprovider) RunInTransaction(fn func() error) error {
+	return dbp.RunInTransactionWithOptions(TxOptions{}, fn)
+}
+
+// RunInTransactionWithOptions runs the provided function inside a transaction like RunInTransaction,
+// the transaction being started with the provided isolation level and access mode.
+// With a retry policy, provided by the options or by the database, the transaction is rolled back and fn runs again from scratch in a new transaction
+// when it fails with an error the policy retries, the attempt being available with TransactionAttempt
+// A nested call cannot change the characteristics of the outer transaction, and returns an error
+// if they are not the zero value, its retry policy being ignored as only the outer transaction can run again
+func (dbp *dbprovider) RunInTransactionWithOptions(opts TxOptions, fn func() error) error {
+	if inTransaction(dbp) {
+		if !opts.isDefault() {
+			return errors.Errorf("Cannot set the options of a transaction nested in another one")
+		}
+		return dbp.runInTransaction(dbp.Tx, fn)
+	}
+	retry := opts.Retry
+	if d, ok := dbp.getDb().(*db); ok && retry == nil {
+		retry = d.transactionRetry
+	}
+	ctx := dbp.ctx
+	defer func() {
+		dbp.ctx = ctx
+	}()
+	begin := func() error {
+		dbp.txOptions = opts
+		defer func() {
+			dbp.txOptions = TxOptions{}
+		}()
+		return dbp.Tx()
+	}
+	for attempt := 1; ; attempt++ {
+		dbp.ctx = context.WithValue(ctx, transactionAttemptKey{}, attempt)
+		err := dbp.runInTransaction(begin, fn)
+		if err == nil || !retry.shouldRetry(err, attempt) {
+			return err
+		}
+		if retry.wait(ctx, attempt) != nil {
+			return err
+		}
+	}
+}
+
+// runInTransaction runs the function inside the transaction started by begin
+func (dbp *dbprovider) runInTransaction(begin func() error, fn func() error) error {
 	shouldRollback := true
-	errTx := dbp.Tx()
+	errTx := begin()
 	if errTx != nil {
 		return errTx
 	}
@@ -139,3 +362,134 @@ func (dbp *dbprovider) RunInTransaction(fn func() error) error {
 	shouldRollback = false
 	return nil
 }
+
+// Tx begins a transaction, or a transaction nested in the current one: its statements run inside a savepoint,
+// released when it is committed and rolled back to when it is rolled back, the outer transaction being left intact
+func (dbp *dbprovider) Tx() error {
+	nested := inTransaction(dbp)
+	if err := dbp.DBProvider.Tx(); err != nil {
+		return err
+	}
+	if nested {
+		dbp.nested = append(dbp.nested, callbacksMark{onCommit: len(dbp.onCommit), onRollback: len(dbp.onRollback)})
+	}
+	return nil
+}
+
+// resetTx provides the DBProvider with a zesty provider outside of any transaction, zesty keeping a transaction
+// which cannot be committed nor rolled back anymore as the current one
+func (dbp *dbprovider) resetTx() {
+	dbp.DBProvider = zesty.NewTempDBProvider(&transactionDB{DB: dbp.getDb(), dbp: dbp})
+	dbp.nested = nil
+}
+
+// nestedSavepoint returns the savepoint of the innermost nested transaction, as named by zesty
+func (dbp *dbprovider) nestedSavepoint() string {
+	return fmt.Sprintf("tx-nested-%d", len(dbp.nested))
+}
+
+// Commit commits the current transaction, and runs the callbacks registered with OnCommit
+// once the outermost transaction is committed, or the ones registered with OnRollback when its commit fails
+func (dbp *dbprovider) Commit() error {
+	if len(dbp.nested) > 0 {
+		// zesty keeps the savepoint of a committed nested transaction, it is released so that they do not pile up
+		tx, ok := dbp.DBProvider.DB().(transactionExecutor)
+		if !ok {
+			return errors.Errorf("Nested transaction is not running inside a transaction")
+		}
+		if err := tx.ReleaseSavepoint(dbp.nestedSavepoint()); err != nil {
+			return err
+		}
+		if err := dbp.DBProvider.Commit(); err != nil {
+			return err
+		}
+		dbp.nested = dbp.nested[:len(dbp.nested)-1]
+		return nil
+	}
+	if err := dbp.DBProvider.Commit(); err != nil {
+		// the transaction is over even though its commit failed, without its changes
+		dbp.resetTx()
+		dbp.endTransaction(false)
+		return err
+	}
+	dbp.endTransaction(true)
+	return nil
+}
+
+// Rollback rolls back the current transaction, and runs the callbacks registered with OnRollback
+// once the outermost transaction is rolled back, even when its rollback fails, or the ones registered inside
+// the nested transaction rolled back
+func (dbp *dbprovider) Rollback() error {
+	if len(dbp.nested) > 0 {
+		name := dbp.nestedSavepoint()
+		if err := dbp.DBProvider.Rollback(); err != nil {
+			return err
+		}
+		// the savepoint outlives the rollback to it, it is released so that it does not pile up
+		if tx, ok := dbp.DBProvider.DB().(transactionExecutor); ok {
+			tx.ReleaseSavepoint(name) //nolint:errcheck
+		}
+		mark := dbp.nested[len(dbp.nested)-1]
+		dbp.nested = dbp.nested[:len(dbp.nested)-1]
+		dbp.rollbackCallbacks(mark)
+		return nil
+	}
+	err := dbp.DBProvider.Rollback()
+	if err != nil {
+		// the transaction is over even though its rollback failed
+		dbp.resetTx()
+	}
+	dbp.endTransaction(false)
+	return err
+}
+
+// endTransaction runs the callbacks registered for the outcome of the outermost transaction, and discards the other ones
+func (dbp *dbprovider) endTransaction(committed bool) {
+	if committed {
+		dbp.onRollback = nil
+		dbp.runCallbacks(&dbp.onCommit)
+		return
+	}
+	dbp.onCommit = nil
+	dbp.runCallbacks(&dbp.onRollback)
+}
+
+// OnCommit registers a callback run after the current transaction is committed, in the order of registration,
+// and discarded if it is rolled back, like the ones registered in a nested transaction which is rolled back.
+// The callback runs immediately when no transaction is open
+func (dbp *dbprovider) OnCommit(fn func()) {
+	if !inTransaction(dbp) {
+		fn()
+		return
+	}
+	dbp.onCommit = append(dbp.onCommit, fn)
+}
+
+// OnRollback registers a callback run after the current transaction is rolled back, in the order of registration,
+// or after the nested transaction it was registered in is rolled back.
+// It is discarded when the transaction is committed. The callback runs immediately when no transaction is open
+func (dbp *dbprovider) OnRollback(fn func()) {
+	if !inTransaction(dbp) {
+		fn()
+		return
+	}
+	dbp.onRollback = append(dbp.onRollback, fn)
+}
+
+// rollbackCallbacks discards the commit callbacks registered since a nested transaction began,
+// and runs the rollback ones, after it is rolled back
+func (dbp *dbprovider) rollbackCallbacks(mark callbacksMark) {
+	dbp.onCommit = dbp.onCommit[:mark.onCommit]
+	callbacks := append([]func(){}, dbp.onRollback[mark.onRollback:]...)
+	dbp.onRollback = dbp.onRollback[:mark.onRollback]
+	dbp.runCallbacks(&callbacks)
+}
+
+// runCallbacks runs the callbacks in order, after emptying the list so that they can register new callbacks
+func (dbp *dbprovider) runCallbacks(callbacks *[]func()) {
+	fns := *callbacks
+	*callbacks = nil
+	for _, fn := range fns {
+		fn()
+	}
+}
@@ -2,14 +2,19 @@ package yaorm_test
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm"
 	"github.com/geoffreybauduin/yaorm/testdata"
 	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/go-gorp/gorp"
 	"github.com/juju/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -153,3 +158,363 @@ func (exec *pgSpecificExecutor) BeforeExec(ctx context.Context, query string, ar
 		args:  args,
 	})
 }
+
+func TestDBProvider_Now(t *testing.T) {
+	defer func() {
+		os.Remove("/tmp/test_test.sqlite")
+		yaorm.UnregisterDB("test")
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:            "test",
+		DSN:             "/tmp/test_test.sqlite",
+		System:          yaorm.DatabaseSqlite3,
+		TimestampsInUTC: true,
+	})
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, dbp.Now().Location())
+
+	paris := time.FixedZone("Paris", 3600)
+	fixed := time.Date(2020, 1, 2, 3, 4, 5, 0, paris)
+	dbp.SetClock(func() time.Time { return fixed })
+	assert.Equal(t, fixed.UTC(), dbp.Now())
+	assert.Equal(t, time.UTC, dbp.Now().Location())
+
+	dbp.SetClock(nil)
+	assert.WithinDuration(t, time.Now(), dbp.Now(), time.Minute)
+}
+
+func TestDBProvider_HasCapacity(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	switch os.Getenv("DB") {
+	case "postgres":
+		assert.True(t, dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+	case "mysql":
+		assert.False(t, dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+	default:
+		version, err := dbp.DB().SelectStr("SELECT sqlite_version()")
+		assert.NoError(t, err)
+		// RETURNING is available from sqlite 3.35
+		assert.Equal(t, versionAtLeast(t, version, 3, 35), dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+		assert.False(t, dbp.HasCapacity(yaorm.DatabaseCapacitySchema))
+	}
+}
+
+// versionAtLeast returns true if the dotted version is greater than or equal to minVersion
+func versionAtLeast(t *testing.T, version string, minVersion ...int) bool {
+	parts := strings.Split(version, ".")
+	for i, min := range minVersion {
+		if i >= len(parts) {
+			return false
+		}
+		v, err := strconv.Atoi(parts[i])
+		assert.NoError(t, err)
+		if v != min {
+			return v > min
+		}
+	}
+	return true
+}
+
+func TestDBProvider_HasCapacity_AbortedTransaction(t *testing.T) {
+	if os.Getenv("DB") != "postgres" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	err = dbp.RunInTransaction(func() error {
+		_, err := dbp.DB().Exec("SELECT * FROM missing_table")
+		assert.Error(t, err)
+		// the server version is not queried inside the aborted transaction
+		assert.True(t, dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+		return err
+	})
+	assert.Error(t, err)
+	assert.True(t, dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+}
+
+func TestDBProvider_RunInTransaction_Nested(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	names := func() []string {
+		models, err := yaorm.GenericSelectAll(dbp, testdata.NewCategoryFilter().OrderBy("name", yaormfilter.OrderingWays.Asc))
+		assert.NoError(t, err)
+		names := []string{}
+		for _, m := range models {
+			names = append(names, m.(*testdata.Category).Name)
+		}
+		return names
+	}
+	saveIn := func(name string) error {
+		m := &testdata.Category{Name: name}
+		m.SetDBP(dbp)
+		return yaorm.GenericSave(m)
+	}
+
+	err = dbp.RunInTransaction(func() error {
+		if err := saveIn("a"); err != nil {
+			return err
+		}
+		errInner := dbp.RunInTransaction(func() error {
+			if err := saveIn("b"); err != nil {
+				return err
+			}
+			return fmt.Errorf("inner failure")
+		})
+		assert.EqualError(t, errInner, "inner failure")
+		errInner = dbp.RunInTransaction(func() error {
+			if err := saveIn("c"); err != nil {
+				return err
+			}
+			return dbp.RunInTransaction(func() error {
+				if err := saveIn("d"); err != nil {
+					return err
+				}
+				assert.EqualError(t, dbp.RunInTransaction(func() error {
+					if err := saveIn("e"); err != nil {
+						return err
+					}
+					return fmt.Errorf("deepest failure")
+				}), "deepest failure")
+				return nil
+			})
+		})
+		assert.NoError(t, errInner)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "d"}, names())
+
+	err = dbp.RunInTransaction(func() error {
+		assert.NoError(t, dbp.RunInTransaction(func() error {
+			return saveIn("f")
+		}))
+		return fmt.Errorf("outer failure")
+	})
+	assert.EqualError(t, err, "outer failure")
+	assert.Equal(t, []string{"a", "c", "d"}, names())
+
+	// transactions begun with Tx nest the same way
+	committed := []string{}
+	assert.NoError(t, dbp.Tx())
+	assert.NoError(t, saveIn("g"))
+	assert.NoError(t, dbp.Tx())
+	dbp.OnCommit(func() { committed = append(committed, "h") })
+	assert.NoError(t, saveIn("h"))
+	assert.NoError(t, dbp.Rollback())
+	assert.NoError(t, dbp.RunInTransaction(func() error {
+		dbp.OnCommit(func() { committed = append(committed, "i") })
+		return saveIn("i")
+	}))
+	assert.NoError(t, dbp.Commit())
+	assert.Equal(t, []string{"a", "c", "d", "g", "i"}, names())
+	assert.Equal(t, []string{"i"}, committed)
+}
+
+func TestDBProvider_RunInTransactionWithOptions(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Isolation: sql.LevelSerializable}, func() error {
+		m := &testdata.Category{Name: "category"}
+		m.SetDBP(dbp)
+		if err := yaorm.GenericSave(m); err != nil {
+			return err
+		}
+		errNested := dbp.RunInTransactionWithOptions(yaorm.TxOptions{Isolation: sql.LevelRepeatableRead}, func() error {
+			return nil
+		})
+		assert.EqualError(t, errNested, "Cannot set the options of a transaction nested in another one")
+		return nil
+	})
+	assert.NoError(t, err)
+	count, err := yaorm.GenericCount(dbp, testdata.NewCategoryFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	errRollback := errors.Errorf("rollback")
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Isolation: sql.LevelSerializable}, func() error {
+		m := &testdata.Category{Name: "rolled back"}
+		m.SetDBP(dbp)
+		if err := yaorm.GenericSave(m); err != nil {
+			return err
+		}
+		errNested := dbp.RunInTransaction(func() error {
+			nested := &testdata.Category{Name: "nested"}
+			nested.SetDBP(dbp)
+			if err := yaorm.GenericSave(nested); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		assert.Equal(t, errRollback, errNested)
+		count, err := yaorm.GenericCount(dbp, testdata.NewCategoryFilter())
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), count)
+		return errRollback
+	})
+	assert.Equal(t, errRollback, err)
+	count, err = yaorm.GenericCount(dbp, testdata.NewCategoryFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Isolation: sql.LevelRepeatableRead, Deferrable: true}, func() error {
+		return nil
+	})
+	assert.NoError(t, err)
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{ReadOnly: true}, func() error {
+		t.Error("read only transactions are not supported by SQLite")
+		return nil
+	})
+	assert.EqualError(t, err, "Read only transactions are not supported by SQLite")
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Isolation: sql.LevelSnapshot}, func() error {
+		t.Error("snapshot isolation is not supported")
+		return nil
+	})
+	assert.EqualError(t, err, "Unsupported isolation level Snapshot")
+	// the failed transactions did not leave any transaction open
+	assert.NoError(t, dbp.RunInTransaction(func() error { return nil }))
+}
+
+func TestDBProvider_RunInTransactionWithOptions_Characteristics(t *testing.T) {
+	if os.Getenv("DB") != "postgres" && os.Getenv("DB") != "mysql" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+
+	opts := yaorm.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true, Deferrable: true}
+	err = dbp.RunInTransactionWithOptions(opts, func() error {
+		if os.Getenv("DB") == "postgres" {
+			for setting, expected := range map[string]string{
+				"transaction_isolation":  "repeatable read",
+				"transaction_read_only":  "on",
+				"transaction_deferrable": "on",
+			} {
+				value, err := dbp.DB().SelectStr(fmt.Sprintf("SELECT current_setting('%s')", setting))
+				assert.NoError(t, err)
+				assert.Equal(t, expected, value, setting)
+			}
+		} else {
+			isolation, err := dbp.DB().SelectStr("SELECT @@transaction_isolation")
+			assert.NoError(t, err)
+			assert.Equal(t, "REPEATABLE-READ", isolation)
+		}
+		m := &testdata.Category{Name: "category"}
+		m.SetDBP(dbp)
+		return yaorm.GenericSave(m)
+	})
+	// the transaction is read only
+	assert.Error(t, err)
+	count, err := yaorm.GenericCount(dbp, testdata.NewCategoryFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestDBProvider_OnCommitOnRollback(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	events := []string{}
+	event := func(name string) func() {
+		return func() {
+			events = append(events, name)
+		}
+	}
+
+	// without transaction, the callbacks run immediately
+	dbp.OnCommit(event("commit"))
+	dbp.OnRollback(event("rollback"))
+	assert.Equal(t, []string{"commit", "rollback"}, events)
+
+	events = []string{}
+	err = dbp.RunInTransaction(func() error {
+		m := &testdata.Category{Name: "category"}
+		m.SetDBP(dbp)
+		if err := yaorm.GenericSave(m); err != nil {
+			return err
+		}
+		m.GetDBP().OnCommit(event("saved"))
+		dbp.OnRollback(event("outer rollback"))
+		assert.EqualError(t, dbp.RunInTransaction(func() error {
+			dbp.OnCommit(event("discarded"))
+			dbp.OnRollback(event("nested rollback"))
+			return fmt.Errorf("nested failure")
+		}), "nested failure")
+		assert.NoError(t, dbp.RunInTransaction(func() error {
+			dbp.OnCommit(event("nested commit"))
+			return nil
+		}))
+		assert.Equal(t, []string{"nested rollback"}, events)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nested rollback", "saved", "nested commit"}, events)
+
+	events = []string{}
+	err = dbp.RunInTransaction(func() error {
+		dbp.OnCommit(event("commit"))
+		dbp.OnRollback(event("rollback"))
+		dbp.OnRollback(func() {
+			// a callback registering another one after the transaction sees it run immediately
+			dbp.OnCommit(event("after rollback"))
+		})
+		return fmt.Errorf("test error")
+	})
+	assert.EqualError(t, err, "test error")
+	assert.Equal(t, []string{"rollback", "after rollback"}, events)
+
+	// a transaction whose commit fails is not committed, and leaves no callback behind
+	events = []string{}
+	err = dbp.RunInTransaction(func() error {
+		dbp.OnCommit(event("commit"))
+		dbp.OnRollback(event("rollback"))
+		// the transaction is rolled back behind the DBProvider, so that its commit fails
+		return dbp.DB().(*yaorm.SqlExecutor).SqlExecutor.(*gorp.Transaction).Rollback()
+	})
+	assert.Equal(t, sql.ErrTxDone, err)
+	assert.Equal(t, []string{"rollback"}, events)
+	events = []string{}
+	assert.NoError(t, dbp.RunInTransaction(func() error {
+		dbp.OnCommit(event("next commit"))
+		return nil
+	}))
+	assert.Equal(t, []string{"next commit"}, events)
+
+	// a transaction whose rollback fails leaves no callback behind either
+	events = []string{}
+	assert.NoError(t, dbp.Tx())
+	dbp.OnCommit(event("commit"))
+	dbp.OnRollback(event("rollback"))
+	assert.NoError(t, dbp.DB().(*yaorm.SqlExecutor).SqlExecutor.(*gorp.Transaction).Rollback())
+	assert.Equal(t, sql.ErrTxDone, dbp.Rollback())
+	assert.Equal(t, []string{"rollback"}, events)
+	events = []string{}
+	assert.NoError(t, dbp.RunInTransaction(func() error {
+		dbp.OnCommit(event("next commit"))
+		return nil
+	}))
+	assert.Equal(t, []string{"next commit"}, events)
+}
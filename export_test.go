@@ -0,0 +1,13 @@
+package yaorm
+
+// SetServerVersion overrides the version of the database server of the DBProvider,
+// so that the queries depending on its capacities can be rendered whatever the server running the tests
+func SetServerVersion(dbp DBProvider, version ...int) {
+	d := dbp.(*dbprovider).getDb().(*db)
+	d.versionLock.Lock()
+	defer d.versionLock.Unlock()
+	d.version = version
+}
+
+// HasCounts exposes whether the rows selected with the filter hold counts
+var HasCounts = hasCounts
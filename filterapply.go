@@ -8,6 +8,17 @@ import (
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
 	"github.com/geoffreybauduin/yaorm/tools"
 	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// joinType is the kind of join rendered for a joined filter
+type joinType string
+
+const (
+	innerJoin joinType = "JOIN"
+	leftJoin  joinType = "LEFT JOIN"
+	rightJoin joinType = "RIGHT JOIN"
+	fullJoin  joinType = "FULL OUTER JOIN"
 )
 
 type filterApplier struct {
@@ -15,6 +26,8 @@ type filterApplier struct {
 	filter    yaormfilter.Filter
 	tableName string
 	dbp       DBProvider
+	// skipValues is true when the value filters have already been placed in the ON clause of the join
+	skipValues bool
 }
 
 type filterFieldApplier struct {
@@ -24,7 +37,8 @@ type filterFieldApplier struct {
 	tagData     []string
 	dbFieldName string
 	isJoining   bool
-	leftJoin    bool
+	joinType    joinType
+	skipValues  bool
 	dbp         DBProvider
 	filter      yaormfilter.Filter
 }
@@ -45,6 +59,98 @@ func getTableFromFilter(f yaormfilter.Filter) *Table {
 	return table
 }
 
+// checkFilter checks the filter of the table can be applied: its locking options, its joins, and the relations it
+// counts to filter or order the rows
+func checkFilter(dbp DBProvider, table *Table, f yaormfilter.Filter) error {
+	if err := checkLockOptions(table.Name(), f.GetSelectOptions()); err != nil {
+		return err
+	}
+	if err := checkJoins(dbp, f); err != nil {
+		return err
+	}
+	return checkCounts(dbp, table, f)
+}
+
+// checkJoins checks the database provides the kinds of the joins of the filter, and that the conditions of the
+// filters joined with their conditions in the ON clause can be placed there
+func checkJoins(dbp DBProvider, f yaormfilter.Filter) error {
+	return walkJoins(f, false, func(joined yaormfilter.Filter, kind joinType, onJoin bool) error {
+		switch {
+		case kind == rightJoin && !dbp.HasCapacity(DatabaseCapacityRightJoin),
+			kind == fullJoin && !dbp.HasCapacity(DatabaseCapacityFullJoin):
+			return errors.Errorf("Database does not provide %s, cannot join table %s", kind, getTableNameFromFilter(joined))
+		}
+		if !onJoin {
+			return nil
+		}
+		valueF := tools.GetNonPtrValue(joined)
+		st := valueF.Type()
+		for i := 0; i < st.NumField(); i++ {
+			dbFieldData, ok := st.Field(i).Tag.Lookup("filter")
+			if !ok || dbFieldData == "-" || !valueF.Field(i).IsValid() || valueF.Field(i).IsNil() {
+				continue
+			}
+			valueFilter, ok := valueF.Field(i).Interface().(yaormfilter.ValueFilter)
+			if !ok {
+				continue
+			}
+			if _, ok := valueFilter.(yaormfilter.ExpressionFilter); !ok {
+				return errors.Errorf("Filter %T on %s cannot be placed in the ON clause of a join", valueFilter, dbFieldData)
+			}
+		}
+		return nil
+	})
+}
+
+// walkJoins calls fn on every filter joined below the filter, with the kind of its join and whether its conditions
+// are placed in the ON clause, as they are rendered by filterFieldApplier
+// onJoin tells whether the conditions of the filter itself are placed in the ON clause
+func walkJoins(f yaormfilter.Filter, onJoin bool, fn func(joined yaormfilter.Filter, kind joinType, onJoin bool) error) error {
+	valueF := tools.GetNonPtrValue(f)
+	if !valueF.IsValid() {
+		return nil
+	}
+	st := valueF.Type()
+	for i := 0; i < st.NumField(); i++ {
+		dbFieldData, ok := st.Field(i).Tag.Lookup("filter")
+		field := valueF.Field(i)
+		if !ok || dbFieldData == "-" || !field.IsValid() || field.Kind() == reflect.Struct || field.IsNil() {
+			continue
+		}
+		filters := []yaormfilter.Filter{}
+		switch v := field.Interface().(type) {
+		case yaormfilter.ValueFilter:
+			continue
+		case yaormfilter.Filter:
+			filters = append(filters, v)
+		case []yaormfilter.Filter:
+			filters = v
+		}
+		applier := &filterFieldApplier{tagData: strings.Split(dbFieldData, ","), filter: f, skipValues: onJoin}
+		applier.setupFromTag()
+		for _, joined := range filters {
+			if !hasAnyFilter(joined) {
+				continue
+			}
+			if !applier.isJoining {
+				// the filters of a slice which is not joined apply on the same table
+				if err := walkJoins(joined, false, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			kind := applier.joinKind(joined)
+			if err := fn(joined, kind, kind != innerJoin); err != nil {
+				return err
+			}
+			if err := walkJoins(joined, kind != innerJoin, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func apply(statement squirrel.SelectBuilder, f yaormfilter.Filter, dbp DBProvider) squirrel.SelectBuilder {
 	applier := &filterApplier{
 		statement: statement,
@@ -54,17 +160,36 @@ func apply(statement squirrel.SelectBuilder, f yaormfilter.Filter, dbp DBProvide
 	}
 	applier.Apply()
 	statement = applier.statement
+	table := getTableFromFilter(f)
+	if condition := softDeleteCondition(dbp, table, f, applier.tableName); condition != "" {
+		if joinsWithoutParent(f) {
+			// the condition would discard the rows joined without any row of the table, like the rows joined
+			// with its soft deleted rows, so these are excluded before the joins
+			alias := dbp.EscapeValue(applier.tableName)
+			statement = statement.From(fmt.Sprintf(
+				"(SELECT * FROM %s AS %s WHERE %s) AS %s", table.NameForQuery(dbp), alias, condition, alias,
+			))
+		} else {
+			statement = statement.Where(condition)
+		}
+	}
+	statement = applyCountFilters(statement, dbp, table, applier.tableName, f)
 	for _, option := range f.GetSelectOptions() {
 		switch option {
-		case yaormfilter.RequestOptions.SelectForUpdate:
-			if dbp.CanSelectForUpdate() {
-				statement = statement.Suffix(fmt.Sprintf(`FOR UPDATE OF %s`, dbp.EscapeValue(applier.tableName)))
-			}
 		case yaormfilter.RequestOptions.SelectDistinct:
 			statement = statement.Distinct()
 		}
 	}
+	if lock := lockClause(dbp, applier.tableName, f.GetSelectOptions()); lock != "" {
+		statement = statement.Suffix(lock)
+	}
 	for _, orderBy := range f.GetOrderBy() {
+		if orderBy.Count {
+			statement = statement.OrderBy(
+				fmt.Sprintf("%s %s", countExpression(dbp, table, applier.tableName, orderBy.Field), orderBy.Way),
+			)
+			continue
+		}
 		statement = statement.OrderBy(
 			fmt.Sprintf("%s.%s %s", dbp.EscapeValue(applier.tableName), dbp.EscapeValue(orderBy.Field), orderBy.Way),
 		)
@@ -91,12 +216,13 @@ func (a *filterApplier) Apply() {
 		}
 		tagData := strings.Split(dbFieldData, ",")
 		applier := &filterFieldApplier{
-			field:     underlyingFilter.Field(i),
-			tagData:   tagData,
-			statement: a.statement,
-			tableName: a.tableName,
-			dbp:       a.dbp,
-			filter:    a.filter,
+			field:      underlyingFilter.Field(i),
+			tagData:    tagData,
+			statement:  a.statement,
+			tableName:  a.tableName,
+			skipValues: a.skipValues,
+			dbp:        a.dbp,
+			filter:     a.filter,
 		}
 		applier.Apply()
 		a.statement = applier.statement
@@ -127,40 +253,86 @@ func (a *filterFieldApplier) fieldIsValid() bool {
 func (a *filterFieldApplier) setupFromTag() {
 	a.dbFieldName = a.tagData[0]
 	a.isJoining = false
-	a.leftJoin = false
+	a.joinType = innerJoin
 
-	// Set up LEFT JOIN from tag
-	if len(a.tagData) == 4 && strings.Contains(a.tagData[1], "join") {
+	// Set up the kind of join from tag
+	if isJoinTag(a.tagData) {
 		a.isJoining = true
-		if strings.Contains(a.tagData[1], "left") {
-			a.leftJoin = true
+		switch {
+		case strings.Contains(a.tagData[1], "left"):
+			a.joinType = leftJoin
+		case strings.Contains(a.tagData[1], "right"):
+			a.joinType = rightJoin
+		case strings.Contains(a.tagData[1], "full"):
+			a.joinType = fullJoin
 		}
 	}
 
 	// Set up LEFT JOIN from filter option
-	if !a.leftJoin {
+	if a.joinType == innerJoin {
 		for _, option := range a.filter.GetSelectOptions() {
 			if option == yaormfilter.RequestOptions.LeftJoin {
-				a.leftJoin = true
+				a.joinType = leftJoin
 			}
 		}
 	}
 }
 
+// joinTypeOf returns the kind of join rendered for the joined filter, its own options taking precedence
+func (a *filterFieldApplier) joinTypeOf(f yaormfilter.Filter) joinType {
+	for _, option := range f.GetSelectOptions() {
+		switch option {
+		case yaormfilter.RequestOptions.LeftJoin:
+			return leftJoin
+		case yaormfilter.RequestOptions.RightJoin:
+			return rightJoin
+		case yaormfilter.RequestOptions.FullJoin:
+			return fullJoin
+		}
+	}
+	return a.joinType
+}
+
+// joinKind returns the kind of join rendered for the joined filter
+func (a *filterFieldApplier) joinKind(f yaormfilter.Filter) joinType {
+	kind := a.joinTypeOf(f)
+	switch {
+	case kind != innerJoin:
+	case a.skipValues:
+		// the parent is outer joined, an inner join would discard the rows kept without the parent
+		kind = leftJoin
+	case shouldJoinload(f) && !hasConditions(f):
+		// the relation is only loaded, the parent rows without it are kept
+		kind = leftJoin
+	}
+	return kind
+}
+
+// joinsWithoutParent returns true if the filter right or full joins a table, selecting rows without any row
+// of the table of the filter
+func joinsWithoutParent(f yaormfilter.Filter) bool {
+	found := false
+	walkJoins(f, false, func(_ yaormfilter.Filter, kind joinType, _ bool) error {
+		found = found || kind == rightJoin || kind == fullJoin
+		return nil
+	})
+	return found
+}
+
 func (a *filterFieldApplier) applyPtr() {
 	valueFilter, ok := a.field.Interface().(yaormfilter.ValueFilter)
 	if ok {
+		if a.skipValues {
+			return
+		}
 		a.statement = valueFilter.Apply(a.statement, a.dbp.EscapeValue(a.tableName), a.dbp.EscapeValue(a.dbFieldName))
 	} else {
 		structFilter, ok := a.field.Interface().(yaormfilter.Filter)
 		if ok {
-			tableName := getTableNameFromFilter(structFilter)
-			if a.isJoining {
-				tableName = fmt.Sprintf("%s_%s", a.tableName, tableName)
-			} else {
+			if !a.isJoining {
 				panic("what the fuck man, you're not joining")
 			}
-			a.applyFilter(structFilter, tableName)
+			a.applyFilter(structFilter, joinAlias(a.tableName, structFilter, -1))
 		} else {
 			// maybe it's another kind of filter ?
 			panic(fmt.Errorf("I DONT KNOW HOW TO HANDLE THAT KIND OF FILTER %+v", a.field.Interface()))
@@ -174,7 +346,7 @@ func (a *filterFieldApplier) applySlice() {
 		f := filterSlice.Index(idx).Interface().(yaormfilter.Filter)
 		tableName := getTableNameFromFilter(f)
 		if a.isJoining {
-			tableName = fmt.Sprintf("%s%d", tableName, idx)
+			tableName = joinAlias(a.tableName, f, idx)
 		}
 		a.applyFilter(f, tableName)
 	}
@@ -184,37 +356,162 @@ func (a *filterFieldApplier) applyFilter(f yaormfilter.Filter, tableName string)
 	if !hasAnyFilter(f) {
 		return
 	}
+	// the conditions on an outer joined table go in the ON clause, so that they do not discard the unmatched rows
+	onJoin := false
 	if a.isJoining {
-		a.join(f, tableName)
+		kind := a.joinKind(f)
+		onJoin = kind != innerJoin
+		a.join(f, tableName, kind, onJoin)
 	}
 	filterApplier := &filterApplier{
-		statement: a.statement,
-		tableName: tableName,
-		filter:    f,
-		dbp:       a.dbp,
+		statement:  a.statement,
+		tableName:  tableName,
+		filter:     f,
+		dbp:        a.dbp,
+		skipValues: onJoin,
 	}
 	filterApplier.Apply()
 	a.statement = filterApplier.statement
 }
 
-func (a *filterFieldApplier) join(f yaormfilter.Filter, tableAlias string) {
+// join joins the table of the filter, which must have been checked with checkFilter, it panics otherwise
+func (a *filterFieldApplier) join(f yaormfilter.Filter, tableAlias string, kind joinType, onJoin bool) {
+	switch {
+	case kind == rightJoin && !a.dbp.HasCapacity(DatabaseCapacityRightJoin),
+		kind == fullJoin && !a.dbp.HasCapacity(DatabaseCapacityFullJoin):
+		panic(errors.Errorf("Database does not provide %s, cannot join table %s", kind, getTableNameFromFilter(f)))
+	}
+	parentAlias, parentField := a.tableName, a.tagData[3]
+	if len(a.tagData) == 7 {
+		// many to many relation, the join table is joined first
+		throughAlias := fmt.Sprintf("%s_%s", tableAlias, a.tagData[4])
+		throughCondition := fmt.Sprintf(
+			`%s as %s on %s.%s = %s.%s`,
+			throughTableName(a.dbp, a.tagData[4]),
+			a.dbp.EscapeValue(throughAlias),
+			a.dbp.EscapeValue(throughAlias),
+			a.dbp.EscapeValue(a.tagData[5]),
+			a.dbp.EscapeValue(a.tableName),
+			a.dbp.EscapeValue(a.tagData[3]),
+		)
+		if condition := throughSoftDeleteCondition(a.dbp, a.tagData[4], throughAlias); condition != "" {
+			throughCondition = fmt.Sprintf("%s AND %s", throughCondition, condition)
+		}
+		a.statement = a.statement.JoinClause(fmt.Sprintf("%s %s", kind, throughCondition))
+		parentAlias, parentField = throughAlias, a.tagData[6]
+	}
 	joinCondition := fmt.Sprintf(
 		`%s as %s on %s.%s = %s.%s`,
 		getTableFromFilter(f).NameForQuery(a.dbp),
 		a.dbp.EscapeValue(tableAlias),
 		a.dbp.EscapeValue(tableAlias),
 		a.dbp.EscapeValue(a.tagData[2]),
-		a.dbp.EscapeValue(a.tableName),
-		a.dbp.EscapeValue(a.tagData[3]),
+		a.dbp.EscapeValue(parentAlias),
+		a.dbp.EscapeValue(parentField),
 	)
-	if !a.leftJoin {
-		a.statement = a.statement.Join(joinCondition)
-	} else {
-		a.statement = a.statement.LeftJoin(joinCondition)
+	// soft delete condition goes in the ON clause so that left joins are kept
+	if condition := softDeleteCondition(a.dbp, getTableFromFilter(f), f, tableAlias); condition != "" {
+		joinCondition = fmt.Sprintf("%s AND %s", joinCondition, condition)
+	}
+	args := []interface{}{}
+	if onJoin {
+		conditions, conditionArgs := joinConditions(a.dbp, f, tableAlias)
+		for _, condition := range conditions {
+			joinCondition = fmt.Sprintf("%s AND %s", joinCondition, condition)
+		}
+		args = conditionArgs
+	}
+	a.statement = a.statement.JoinClause(fmt.Sprintf("%s %s", kind, joinCondition), args...)
+}
+
+// joinConditions returns the conditions of the value filters of the joined filter, to be placed in the ON clause
+// the filter must have been checked with checkFilter, it panics if a value filter cannot provide its condition
+func joinConditions(dbp DBProvider, f yaormfilter.Filter, tableAlias string) ([]string, []interface{}) {
+	conditions, args := []string{}, []interface{}{}
+	valueF := tools.GetNonPtrValue(f)
+	st := valueF.Type()
+	for i := 0; i < st.NumField(); i++ {
+		dbFieldData, ok := st.Field(i).Tag.Lookup("filter")
+		if !ok || dbFieldData == "-" {
+			continue
+		}
+		field := valueF.Field(i)
+		if !field.IsValid() || field.IsNil() {
+			continue
+		}
+		valueFilter, ok := field.Interface().(yaormfilter.ValueFilter)
+		if !ok {
+			continue
+		}
+		expressionFilter, ok := valueFilter.(yaormfilter.ExpressionFilter)
+		if !ok {
+			panic(errors.Errorf("Filter %T on %s cannot be placed in the ON clause of a join", valueFilter, dbFieldData))
+		}
+		column := fmt.Sprintf("%s.%s", dbp.EscapeValue(tableAlias), dbp.EscapeValue(strings.Split(dbFieldData, ",")[0]))
+		sql, sqlArgs, err := predicateToSql(expressionFilter.Predicate(column))
+		if err != nil {
+			panic(err)
+		}
+		if sql == "" {
+			continue
+		}
+		conditions = append(conditions, sql)
+		args = append(args, sqlArgs...)
 	}
+	return conditions, args
+}
+
+// predicateToSql renders a predicate accepted by squirrel's Where
+func predicateToSql(pred interface{}) (string, []interface{}, error) {
+	switch p := pred.(type) {
+	case nil:
+		return "", nil, nil
+	case squirrel.Sqlizer:
+		return p.ToSql()
+	case map[string]interface{}:
+		return squirrel.Eq(p).ToSql()
+	case string:
+		return p, nil, nil
+	}
+	return "", nil, errors.Errorf("Unknown predicate %T", pred)
+}
+
+// joinAlias returns the alias of the table joined by the filter below the parent alias, idx being the position
+// of the filter when it belongs to a slice of filters, -1 otherwise
+func joinAlias(parentAlias string, f yaormfilter.Filter, idx int) string {
+	if aliased, ok := f.(yaormfilter.AliasedFilter); ok && aliased.GetAlias() != "" {
+		return aliased.GetAlias()
+	}
+	if idx < 0 {
+		return fmt.Sprintf("%s_%s", parentAlias, getTableNameFromFilter(f))
+	}
+	return fmt.Sprintf("%s_%s%d", parentAlias, getTableNameFromFilter(f), idx)
+}
+
+// isJoinTag returns whether the filter tag joins another table, either directly with
+// `filter:"table,join,field,parentField"`, or through a join table with
+// `filter:"table,join,field,parentField,joinTable,joinTableParentField,joinTableField"`
+func isJoinTag(tagData []string) bool {
+	return (len(tagData) == 4 || len(tagData) == 7) && strings.Contains(tagData[1], "join")
 }
 
 func hasAnyFilter(f yaormfilter.Filter) bool {
+	valueF := tools.GetNonPtrValue(f)
+	if !valueF.IsValid() {
+		return false
+	}
+	for _, option := range f.GetSelectOptions() {
+		switch option {
+		case yaormfilter.RequestOptions.Join, yaormfilter.RequestOptions.Joinload,
+			yaormfilter.RequestOptions.RightJoin, yaormfilter.RequestOptions.FullJoin:
+			return true
+		}
+	}
+	return hasConditions(f)
+}
+
+// hasConditions returns true if the filter has a value filter, or a joined filter restricting the rows selected
+func hasConditions(f yaormfilter.Filter) bool {
 	valueF := tools.GetNonPtrValue(f)
 	if !valueF.IsValid() {
 		return false
@@ -235,9 +532,75 @@ func hasAnyFilter(f yaormfilter.Filter) bool {
 			if ok && hasAnyFilter(field.Interface().(yaormfilter.Filter)) {
 				return true
 			}
+			filters, ok := field.Interface().([]yaormfilter.Filter)
+			for idx := 0; ok && idx < len(filters); idx++ {
+				if hasAnyFilter(filters[idx]) {
+					return true
+				}
+			}
 		} else {
 			panic(field)
 		}
 	}
 	return false
 }
+
+// joinAliases returns the aliases of the tables joined by the filter, indexed by alias,
+// with the name of the table they refer to, following the naming used by filterFieldApplier
+func joinAliases(f yaormfilter.Filter, tableName string, aliases map[string]string) {
+	valueF := tools.GetNonPtrValue(f)
+	if !valueF.IsValid() {
+		return
+	}
+	st := valueF.Type()
+	for i := 0; i < st.NumField(); i++ {
+		dbFieldData, ok := st.Field(i).Tag.Lookup("filter")
+		if !ok || dbFieldData == "-" {
+			continue
+		}
+		tagData := strings.Split(dbFieldData, ",")
+		if !isJoinTag(tagData) {
+			continue
+		}
+		field := valueF.Field(i)
+		if !field.IsValid() || field.IsNil() {
+			continue
+		}
+		switch v := field.Interface().(type) {
+		case yaormfilter.Filter:
+			if hasAnyFilter(v) {
+				joined := getTableNameFromFilter(v)
+				alias := joinAlias(tableName, v, -1)
+				aliases[alias] = joined
+				joinAliases(v, alias, aliases)
+			}
+		case []yaormfilter.Filter:
+			for idx, elem := range v {
+				if hasAnyFilter(elem) {
+					joined := getTableNameFromFilter(elem)
+					alias := joinAlias(tableName, elem, idx)
+					aliases[alias] = joined
+					joinAliases(elem, alias, aliases)
+				}
+			}
+		}
+	}
+}
+
+// softDeleteCondition returns the condition restricting the rows of a soft deletable table
+// according to the options of the filter, or an empty string when no restriction applies
+func softDeleteCondition(dbp DBProvider, table *Table, f yaormfilter.Filter, tableAlias string) string {
+	if table == nil || table.SoftDeleteField() == "" {
+		return ""
+	}
+	column := fmt.Sprintf("%s.%s", dbp.EscapeValue(tableAlias), dbp.EscapeValue(table.SoftDeleteField()))
+	for _, option := range f.GetSelectOptions() {
+		switch option {
+		case yaormfilter.RequestOptions.WithDeleted:
+			return ""
+		case yaormfilter.RequestOptions.OnlyDeleted:
+			return fmt.Sprintf("%s IS NOT NULL", column)
+		}
+	}
+	return fmt.Sprintf("%s IS NULL", column)
+}
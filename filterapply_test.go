@@ -3,6 +3,9 @@ package yaorm_test
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/geoffreybauduin/yaorm"
@@ -282,3 +285,228 @@ func TestFilterApply_ILike(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, models, 2)
 }
+
+func TestFilterApply_JoinAlias(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+	post := &testdata.Post{Subject: "parent"}
+	saveModel(t, dbp, post)
+	saveModel(t, dbp, &testdata.PostMetadata{PostID: post.ID, Key: "lang", Value: "en"})
+	child := &testdata.Post{Subject: "child", ParentPostID: post.ID}
+	saveModel(t, dbp, child)
+	saveModel(t, dbp, &testdata.PostMetadata{PostID: child.ID, Key: "lang", Value: "fr"})
+
+	// the aliases of a slice of filters are prefixed with their parent alias, so the same table may be joined at
+	// several depths
+	var summaries []struct {
+		ID        int64  `db:"id"`
+		Lang      string `db:"lang"`
+		ChildLang string `db:"child_lang"`
+	}
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Metadata(
+		testdata.NewPostMetadataFilter().Key(yaormfilter.Equals("lang")),
+	).ChildrenPosts(testdata.NewPostFilter().Metadata(
+		testdata.NewPostMetadataFilter().Key(yaormfilter.Equals("lang")),
+	)), &summaries,
+		"post.id", "post_post_metadata0.value AS lang", "post_post_post_metadata0.value AS child_lang",
+	)
+	assert.Nil(t, err)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, post.ID, summaries[0].ID)
+		assert.Equal(t, "en", summaries[0].Lang)
+		assert.Equal(t, "fr", summaries[0].ChildLang)
+	}
+
+	childMetadata := testdata.NewPostMetadataFilter().Key(yaormfilter.Equals("lang"))
+	childMetadata.As("child_metadata")
+	summaries = nil
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Metadata(
+		testdata.NewPostMetadataFilter().Key(yaormfilter.Equals("lang")),
+	).ChildrenPosts(testdata.NewPostFilter().Metadata(childMetadata)), &summaries,
+		"post.id", "post_post_metadata0.value AS lang", "child_metadata.value AS child_lang",
+	)
+	assert.Nil(t, err)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, post.ID, summaries[0].ID)
+		assert.Equal(t, "en", summaries[0].Lang)
+		assert.Equal(t, "fr", summaries[0].ChildLang)
+	}
+}
+
+func TestFilterApply_LeftJoinConditionsOnJoin(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	category2 := &testdata.Category{Name: "category2"}
+	saveModel(t, dbp, category2)
+	saveModel(t, dbp, &testdata.Post{Subject: "subject", CategoryID: category.ID})
+	saveModel(t, dbp, &testdata.Post{Subject: "subject2", CategoryID: category2.ID})
+
+	categoryFilter := testdata.NewCategoryFilter().Name(yaormfilter.Equals("category"))
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(categoryFilter))
+	assert.Nil(t, err)
+	assert.Len(t, models, 1)
+
+	categoryFilter = testdata.NewCategoryFilter().Name(yaormfilter.Equals("category"))
+	categoryFilter.LeftJoin()
+	var summaries []postSummary
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Category(categoryFilter).OrderBy(
+		"id", yaormfilter.OrderingWays.Asc,
+	), &summaries, "post.id", "subject", "COALESCE(post_category.name, '') AS category_name")
+	assert.Nil(t, err)
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "category", summaries[0].CategoryName)
+		assert.Equal(t, "", summaries[1].CategoryName)
+	}
+}
+
+func TestFilterApply_RightAndFullJoin(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	saveModel(t, dbp, &testdata.Category{Name: "empty"})
+	saveModel(t, dbp, &testdata.Post{Subject: "subject", CategoryID: category.ID})
+	// the category of a soft deleted post is joined without any post
+	deletedCategory := &testdata.Category{Name: "deleted"}
+	saveModel(t, dbp, deletedCategory)
+	deleted := &testdata.Post{Subject: "deleted", CategoryID: deletedCategory.ID}
+	saveModel(t, dbp, deleted)
+	_, err = yaorm.GenericDelete(deleted)
+	assert.Nil(t, err)
+
+	var names []struct {
+		Name string `db:"name"`
+	}
+	categoryFilter := testdata.NewCategoryFilter()
+	categoryFilter.RightJoin()
+	selectNames := func() error {
+		return yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Category(categoryFilter), &names, "post_category.name")
+	}
+	if !dbp.HasCapacity(yaorm.DatabaseCapacityRightJoin) {
+		assert.EqualError(t, selectNames(), "Database does not provide RIGHT JOIN, cannot join table category")
+	} else {
+		assert.Nil(t, selectNames())
+		assert.Len(t, names, 3)
+	}
+
+	names = nil
+	categoryFilter = testdata.NewCategoryFilter()
+	categoryFilter.FullJoin()
+	if !dbp.HasCapacity(yaorm.DatabaseCapacityFullJoin) {
+		assert.EqualError(t, selectNames(), "Database does not provide FULL OUTER JOIN, cannot join table category")
+		_, err = yaorm.GenericCount(dbp, testdata.NewPostFilter().Category(categoryFilter))
+		assert.Error(t, err)
+	} else {
+		assert.Nil(t, selectNames())
+		assert.Len(t, names, 3)
+	}
+}
+
+func TestFilterApply_OuterJoinConditionsOnJoin(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	tmpFile := fmt.Sprintf("/tmp/yaorm_test_%d.sqlite", rand.Int())
+	defer func() {
+		yaorm.UnregisterDB("test")
+		os.Remove(tmpFile)
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:             "test",
+		DSN:              tmpFile,
+		System:           yaorm.DatabaseSqlite3,
+		AutoCreateTables: true,
+		ExecutorHook:     &customExecutorHookForTesting{},
+	})
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+	// right and full joins are rendered whatever the version of the SQLite library running the tests
+	yaorm.SetServerVersion(dbp, 3, 39)
+
+	for kind, join := range map[string]func(*testdata.CategoryFilter){
+		"RIGHT JOIN":      func(f *testdata.CategoryFilter) { f.RightJoin() },
+		"FULL OUTER JOIN": func(f *testdata.CategoryFilter) { f.FullJoin() },
+	} {
+		categoryFilter := testdata.NewCategoryFilter().Name(yaormfilter.Equals("category"))
+		join(categoryFilter)
+		query_ = ""
+		yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(categoryFilter))
+		// the soft deleted posts are excluded before the join, so that their categories are kept,
+		// and no WHERE clause discards the rows without any post
+		assert.True(t, strings.HasSuffix(query_, fmt.Sprintf(
+			`FROM (SELECT * FROM "post" AS "post" WHERE "post"."deleted_at" IS NULL) AS "post" `+
+				`%s "category" as "post_category" on "post_category"."id" = "post"."category_id" AND "post_category"."name" = $1`,
+			kind,
+		)), query_)
+	}
+}
+
+func TestFilterApply_InnerJoinNestedInOuterJoin(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	tmpFile := fmt.Sprintf("/tmp/yaorm_test_%d.sqlite", rand.Int())
+	defer func() {
+		yaorm.UnregisterDB("test")
+		os.Remove(tmpFile)
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:             "test",
+		DSN:              tmpFile,
+		System:           yaorm.DatabaseSqlite3,
+		AutoCreateTables: true,
+		ExecutorHook:     &customExecutorHookForTesting{},
+	})
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+	yaorm.SetServerVersion(dbp, 3, 39)
+
+	// the category of the children is joined inside the right join of the children, without discarding
+	// the children without category
+	childrenFilter := testdata.NewPostFilter().Category(testdata.NewCategoryFilter().Name(yaormfilter.Equals("category")))
+	childrenFilter.RightJoin()
+	query_ = ""
+	yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().ChildrenPosts(childrenFilter))
+	assert.True(t, strings.HasSuffix(query_,
+		`RIGHT JOIN "post" as "post_post" on "post_post"."parent_post_id" = "post"."id" AND "post_post"."deleted_at" IS NULL `+
+			`LEFT JOIN "category" as "post_post_category" on "post_post_category"."id" = "post_post"."category_id" AND "post_post_category"."name" = $1`,
+	), query_)
+}
+
+// valueFilterWithoutExpression hides the ExpressionFilter implementation of the value filter it wraps
+type valueFilterWithoutExpression struct {
+	yaormfilter.ValueFilter
+}
+
+func TestFilterApply_OuterJoinConditionsWithoutExpression(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.Nil(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.Nil(t, err)
+
+	categoryFilter := testdata.NewCategoryFilter().Name(valueFilterWithoutExpression{yaormfilter.Equals("category")})
+	categoryFilter.LeftJoin()
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(categoryFilter))
+	assert.EqualError(t, err, "Filter yaorm_test.valueFilterWithoutExpression on name cannot be placed in the ON clause of a join")
+	_, err = yaorm.GenericCount(dbp, testdata.NewPostFilter().Category(categoryFilter))
+	assert.Error(t, err)
+
+	// the conditions of an inner join are placed in the WHERE clause
+	categoryFilter = testdata.NewCategoryFilter().Name(valueFilterWithoutExpression{yaormfilter.Equals("category")})
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(categoryFilter))
+	assert.Nil(t, err)
+}
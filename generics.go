@@ -0,0 +1,102 @@
+package yaorm
+
+import (
+	"reflect"
+
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// tableOf returns the table registered for the model type T
+func tableOf[T Model]() (*Table, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Ptr {
+		return nil, errors.Errorf("Model type %s must be a pointer to a struct", t)
+	}
+	table, ok := tableByType[t.Elem()]
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+	return table, nil
+}
+
+// filterFor returns the filter to use to select models of type T
+// a nil filter selects every row of the table
+func filterFor[T Model](filter yaormfilter.Filter) (yaormfilter.Filter, error) {
+	table, err := tableOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return table.NewFilter()
+	}
+	filterTable, err := GetTableByFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if filterTable != table {
+		return nil, errors.Errorf("Filter %T does not select models from table %s", filter, table.Name())
+	}
+	return filter, nil
+}
+
+// SelectAll selects all rows in the database, see GenericSelectAll
+// a nil filter selects every row of the table of T
+func SelectAll[T Model](dbp DBProvider, filter yaormfilter.Filter) ([]T, error) {
+	filter, err := filterFor[T](filter)
+	if err != nil {
+		return nil, err
+	}
+	models, err := GenericSelectAll(dbp, filter)
+	if err != nil {
+		return nil, err
+	}
+	typed := make([]T, 0, len(models))
+	for _, m := range models {
+		typed = append(typed, m.(T))
+	}
+	return typed, nil
+}
+
+// SelectOne selects one row in the database, see GenericSelectOne
+// a nil filter selects any row of the table of T
+func SelectOne[T Model](dbp DBProvider, filter yaormfilter.Filter) (T, error) {
+	var zero T
+	filter, err := filterFor[T](filter)
+	if err != nil {
+		return zero, err
+	}
+	m, err := GenericSelectOne(dbp, filter)
+	if err != nil {
+		return zero, err
+	}
+	return m.(T), nil
+}
+
+// Get selects the row of the table of T having the provided primary key values, see GenericGet
+func Get[T Model](dbp DBProvider, pkValues ...interface{}) (T, error) {
+	var zero T
+	table, err := tableOf[T]()
+	if err != nil {
+		return zero, err
+	}
+	m, err := table.NewModel()
+	if err != nil {
+		return zero, err
+	}
+	err = GenericGet(dbp, m, pkValues...)
+	if err != nil {
+		return zero, err
+	}
+	return m.(T), nil
+}
+
+// Count counts the rows in the database, see GenericCount
+// a nil filter counts every row of the table of T
+func Count[T Model](dbp DBProvider, filter yaormfilter.Filter) (uint64, error) {
+	filter, err := filterFor[T](filter)
+	if err != nil {
+		return 0, err
+	}
+	return GenericCount(dbp, filter)
+}
@@ -0,0 +1,55 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerics(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	first := &testdata.Category{Name: "first"}
+	saveModel(t, dbp, first)
+	second := &testdata.Category{Name: "second"}
+	saveModel(t, dbp, second)
+
+	categories, err := yaorm.SelectAll[*testdata.Category](dbp, nil)
+	assert.NoError(t, err)
+	assert.Len(t, categories, 2)
+
+	categories, err = yaorm.SelectAll[*testdata.Category](dbp, testdata.NewCategoryFilter().Name(yaormfilter.Equals("second")))
+	assert.NoError(t, err)
+	if assert.Len(t, categories, 1) {
+		assert.Equal(t, second.ID, categories[0].ID)
+	}
+
+	category, err := yaorm.SelectOne[*testdata.Category](dbp, testdata.NewCategoryFilter().Name(yaormfilter.Equals("first")))
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, category.ID)
+
+	category, err = yaorm.Get[*testdata.Category](dbp, second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", category.Name)
+
+	_, err = yaorm.Get[*testdata.Category](dbp, second.ID+10)
+	assert.True(t, errors.IsNotFound(err))
+
+	_, err = yaorm.Get[*testdata.Category](dbp, first.ID, second.ID)
+	assert.Error(t, err)
+
+	count, err := yaorm.Count[*testdata.Category](dbp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	_, err = yaorm.SelectAll[*testdata.Category](dbp, testdata.NewPostFilter())
+	assert.Error(t, err)
+}
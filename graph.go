@@ -0,0 +1,191 @@
+package yaorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/juju/errors"
+)
+
+// GenericSaveGraph saves the model along with the models set on its `filterload` fields, recursively,
+// in a single transaction:
+//   - the models it references (`filterload:"table,fk"`) are saved first, and their key is copied into the fk field
+//   - then the model is saved
+//   - then the models referencing it (`filterload:"table,field,fk"`) are saved, after their fk field is set
+//   - and the models related through a join table are saved and attached when they are not yet
+//
+// Every model is saved once, even if it appears several times in the graph
+// panics if model is nil or not linked to dbp
+func GenericSaveGraph(m Model) error {
+	dbp := m.GetDBP()
+	return dbp.RunInTransaction(func() error {
+		return saveGraph(dbp, m, map[Model]bool{})
+	})
+}
+
+func saveGraph(dbp DBProvider, m Model, saved map[Model]bool) error {
+	if saved[m] {
+		return nil
+	}
+	saved[m] = true
+	m.SetDBP(dbp)
+	reflectedM := tools.GetNonPtrValue(m)
+	st := reflectedM.Type()
+	// models referenced by m must exist before it
+	for i := 0; i < st.NumField(); i++ {
+		tagData, ok := graphRelation(st.Field(i))
+		if !ok || len(tagData) != 2 || reflectedM.Field(i).Kind() != reflect.Ptr || reflectedM.Field(i).IsNil() {
+			continue
+		}
+		related := reflectedM.Field(i).Interface().(Model)
+		err := saveGraph(dbp, related, saved)
+		if err != nil {
+			return err
+		}
+		key, err := primaryKeyValue(related)
+		if err != nil {
+			return err
+		}
+		err = setGraphField(m, tagData[1], key)
+		if err != nil {
+			return err
+		}
+	}
+	err := GenericSave(m)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < st.NumField(); i++ {
+		tagData, ok := graphRelation(st.Field(i))
+		if !ok || len(tagData) == 2 {
+			continue
+		}
+		for _, related := range graphModels(reflectedM.Field(i)) {
+			if relation, ok := parseThroughRelation(strings.Join(tagData, ",")); ok {
+				err = saveThroughGraph(dbp, m, relation, related, saved)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if len(tagData) != 3 {
+				continue
+			}
+			idx, _ := getFieldInModel(m, "db", tagData[1])
+			if idx < 0 {
+				return errors.Errorf("Cannot find field %s in model %T", tagData[1], m)
+			}
+			err = setGraphField(related, tagData[2], reflect.Indirect(reflectedM.Field(idx)))
+			if err != nil {
+				return err
+			}
+			err = saveGraph(dbp, related, saved)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveThroughGraph saves the model related through a join table, and attaches it if it was not yet
+func saveThroughGraph(dbp DBProvider, m Model, relation throughRelation, related Model, saved map[Model]bool) error {
+	err := saveGraph(dbp, related, saved)
+	if err != nil {
+		return err
+	}
+	parentKey, err := throughParentKey(m, relation)
+	if err != nil {
+		return err
+	}
+	key, err := primaryKeyValue(related)
+	if err != nil {
+		return err
+	}
+	statement := dbp.getStatementGenerator().Select("COUNT(*) AS count").From(fmt.Sprintf(
+		"%s AS %s", throughTableName(dbp, relation.through), dbp.EscapeValue(relation.through),
+	)).Where(squirrel.Eq{
+		dbp.EscapeValue(relation.throughParent): parentKey,
+		dbp.EscapeValue(relation.throughTarget): key.Interface(),
+	})
+	// a detached link is attached again, GenericAttach restoring it
+	if condition := throughSoftDeleteCondition(dbp, relation.through, relation.through); condition != "" {
+		statement = statement.Where(condition)
+	}
+	query, args, err := statement.ToSql()
+	if err != nil {
+		return err
+	}
+	counter := &cnt{}
+	err = dbp.DB().SelectOne(counter, query, args...)
+	if err != nil || counter.Count > 0 {
+		return err
+	}
+	return GenericAttach(m, relation.table, related)
+}
+
+// graphRelation returns the parts of the filterload tag of the field
+func graphRelation(field reflect.StructField) ([]string, bool) {
+	tag, ok := field.Tag.Lookup("filterload")
+	if !ok || tag == "-" {
+		return nil, false
+	}
+	return strings.Split(tag, ","), true
+}
+
+// graphModels returns the models set on a relation field, either a pointer or a slice
+func graphModels(field reflect.Value) []Model {
+	models := []Model{}
+	switch field.Kind() {
+	case reflect.Ptr:
+		if !field.IsNil() {
+			models = append(models, field.Interface().(Model))
+		}
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			if m, ok := field.Index(i).Interface().(Model); ok && !field.Index(i).IsNil() {
+				models = append(models, m)
+			}
+		}
+	}
+	return models
+}
+
+// primaryKeyValue returns the value of the single primary key of the model
+func primaryKeyValue(m Model) (reflect.Value, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if len(table.Keys()) != 1 {
+		return reflect.Value{}, errors.Errorf("Table %s must have a single primary key to be referenced", table.Name())
+	}
+	return reflect.Indirect(tools.GetNonPtrValue(m).Field(table.FieldIndex(table.Keys()[0]))), nil
+}
+
+// setGraphField sets the value on the field of the model having the provided db tag
+func setGraphField(m Model, dbField string, value reflect.Value) error {
+	idx, _ := getFieldInModel(m, "db", dbField)
+	if idx < 0 {
+		return errors.Errorf("Cannot find field %s in model %T", dbField, m)
+	}
+	field := tools.GetNonPtrValue(m).Field(idx)
+	fieldType := field.Type()
+	if field.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if !value.Type().ConvertibleTo(fieldType) {
+		return errors.Errorf("Cannot set %s to field %s of model %T, expecting %s", value.Type(), dbField, m, fieldType)
+	}
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(fieldType)
+		ptr.Elem().Set(value.Convert(fieldType))
+		field.Set(ptr)
+		return nil
+	}
+	field.Set(value.Convert(fieldType))
+	return nil
+}
@@ -0,0 +1,115 @@
+package yaorm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericSaveGraph(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	tag := &testdata.Tag{Tag: "tag"}
+	post := &testdata.Post{
+		Subject:  "subject",
+		Category: &testdata.Category{Name: "category"},
+		Metadata: []*testdata.PostMetadata{{Key: "author", Value: "me"}, {Key: "lang", Value: "en"}},
+		Tags:     []*testdata.Tag{tag},
+	}
+	post.SetDBP(dbp)
+	assert.NoError(t, yaorm.GenericSaveGraph(post))
+	assert.NotZero(t, post.Category.ID)
+	assert.Equal(t, post.Category.ID, post.CategoryID)
+	assert.NotZero(t, tag.ID)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(post.ID)).Category(
+		testdata.NewCategoryFilter().Subqueryload(),
+	).Metadata(
+		testdata.NewPostMetadataFilter().Subqueryload(),
+	).Tags(
+		testdata.NewTagFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	foundPost := found.(*testdata.Post)
+	if assert.NotNil(t, foundPost.Category) {
+		assert.Equal(t, "category", foundPost.Category.Name)
+	}
+	assert.Len(t, foundPost.Metadata, 2)
+	assert.Len(t, foundPost.Tags, 1)
+
+	// saving again updates the models, without attaching the tag twice
+	post.Category.Name = "renamed"
+	assert.NoError(t, yaorm.GenericSaveGraph(post))
+	category, err := yaorm.Get[*testdata.Category](dbp, post.CategoryID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", category.Name)
+	count, err := yaorm.Count[*testdata.PostTag](dbp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	// a detached tag is attached again
+	_, err = yaorm.GenericDetach(post, "tag", tag)
+	assert.NoError(t, err)
+	assert.NoError(t, yaorm.GenericSaveGraph(post))
+	found, err = yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(post.ID)).Tags(
+		testdata.NewTagFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	assert.Len(t, found.(*testdata.Post).Tags, 1)
+}
+
+type invalidGraphPost struct {
+	yaorm.DatabaseModel
+	ID         int64              `db:"id"`
+	CategoryID bool               `db:"category_id"`
+	Category   *testdata.Category `db:"-" filterload:"category,category_id"`
+}
+
+func TestGenericSaveGraph_InvalidForeignKey(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	post := &invalidGraphPost{Category: &testdata.Category{Name: "category"}}
+	post.SetDBP(dbp)
+	err = yaorm.GenericSaveGraph(post)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Cannot set int64 to field category_id")
+	}
+	count, err := yaorm.Count[*testdata.Category](dbp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestGenericSaveGraph_Rollback(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	// metadata cannot be saved anymore
+	_, err = dbp.DB().Exec(`DROP TABLE post_metadata`)
+	assert.NoError(t, err)
+	post := &testdata.Post{
+		Subject:  "subject",
+		Category: &testdata.Category{Name: "category"},
+		Metadata: []*testdata.PostMetadata{{Key: "key", Value: "value"}},
+	}
+	post.SetDBP(dbp)
+	assert.Error(t, yaorm.GenericSaveGraph(post))
+	count, err := yaorm.Count[*testdata.Category](dbp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
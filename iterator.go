@@ -0,0 +1,204 @@
+package yaorm
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+// Iterator streams the rows selected by GenericSelectIterator, one model at a time,
+// instead of loading the whole result set in memory
+//
+//	it, err := yaorm.GenericSelectIterator(dbp, filter)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		m := it.Model()
+//	}
+//	return it.Err()
+type Iterator struct {
+	dbp     DBProvider
+	filter  yaormfilter.Filter
+	table   *Table
+	rows    *sql.Rows
+	scanner *rowScanner
+	hook    ExecutorHook
+	ctx     context.Context
+	query   string
+	args    []interface{}
+	// models read from the rows but not yet returned, their relations are loaded by window
+	window     []Model
+	windowSize int
+	current    Model
+	err        error
+	closed     bool
+}
+
+// GenericSelectIterator selects the rows in the database and returns an iterator over them
+// Relations requested by the filter are subqueryloaded by windows of parents, see Iterator.WithWindow
+// The iterator must be closed once done with it
+// Loading relations while rows are still pending requires another connection: inside a transaction,
+// some drivers (like lib/pq) do not support it, use a window larger than the result set
+// panics if filter or dbp is nil
+func GenericSelectIterator(dbp DBProvider, filter yaormfilter.Filter) (*Iterator, error) {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	m, err := table.NewModel()
+	if err != nil {
+		return nil, err
+	}
+	statement, err := buildSelect(dbp, m, buildSelectColumns{
+		loadColumns:     filter.GetLoadColumns(),
+		dontLoadColumns: filter.GetDontLoadColumns(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	loads, err := joinloads(table, filter)
+	if err != nil {
+		return nil, err
+	}
+	statement, err = selectCountColumns(dbp, selectJoinloadColumns(dbp, statement, loads), table, filter)
+	if err != nil {
+		return nil, err
+	}
+	statement = apply(statement, filter, dbp)
+	query, params, err := statement.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	executor, err := sqlExecutor(dbp)
+	if err != nil {
+		return nil, err
+	}
+	it := &Iterator{
+		dbp:        dbp,
+		filter:     filter,
+		table:      table,
+		hook:       executor.db.ExecutorHook(),
+		ctx:        executor.ctx,
+		query:      query,
+		args:       params,
+		windowSize: loadStep,
+	}
+	it.hook.BeforeSelect(it.ctx, query, params...)
+	it.rows, err = executor.SqlExecutor.Query(query, params...)
+	if err != nil {
+		it.hook.AfterSelect(it.ctx, query, params...)
+		return nil, err
+	}
+	columns, err := it.rows.Columns()
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
+	it.scanner, err = newRowScanner(dbp, table, loads, columns)
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// GenericSelectIter selects the rows in the database and calls fn on each of them, one model at a time
+// Iteration stops at the first error returned by fn
+// panics if filter or dbp is nil
+func GenericSelectIter(dbp DBProvider, filter yaormfilter.Filter, fn func(Model) error) error {
+	it, err := GenericSelectIterator(dbp, filter)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		err = fn(it.Model())
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// WithWindow sets the number of models read ahead to subqueryload their relations together
+// Must be called before the first call to Next
+func (it *Iterator) WithWindow(size int) *Iterator {
+	if size > 0 {
+		it.windowSize = size
+	}
+	return it
+}
+
+// Next prepares the next model, returning false when there are no more rows or an error occurred
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.window) == 0 && !it.fillWindow() {
+		return false
+	}
+	it.current, it.window = it.window[0], it.window[1:]
+	return true
+}
+
+// fillWindow reads the next window of rows, and loads their relations
+func (it *Iterator) fillWindow() bool {
+	if it.closed {
+		return false
+	}
+	window := make([]Model, 0, it.windowSize)
+	for len(window) < it.windowSize && it.rows.Next() {
+		m, err := it.scan()
+		if err != nil {
+			it.err = err
+			it.Close()
+			return false
+		}
+		window = append(window, m)
+	}
+	if len(window) < it.windowSize {
+		it.err = it.rows.Err()
+		it.Close()
+	}
+	if it.err != nil || len(window) == 0 {
+		return false
+	}
+	it.err = finishSelect(it.dbp, window, it.filter)
+	if it.err != nil {
+		return false
+	}
+	it.window = window
+	return true
+}
+
+func (it *Iterator) scan() (Model, error) {
+	m, err := it.table.NewModel()
+	if err != nil {
+		return nil, err
+	}
+	return m, it.scanner.scan(it.rows, m)
+}
+
+// Model returns the current model, prepared by Next
+func (it *Iterator) Model() Model {
+	return it.current
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the rows of the iterator, it can be called several times
+func (it *Iterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	err := it.rows.Close()
+	it.hook.AfterSelect(it.ctx, it.query, it.args...)
+	return err
+}
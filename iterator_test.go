@@ -0,0 +1,85 @@
+package yaorm_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericSelectIterator(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	expected := map[int64]string{}
+	for i := 0; i < 5; i++ {
+		article := &testdata.Article{Title: fmt.Sprintf("article %d", i)}
+		saveModel(t, dbp, article)
+		saveModel(t, dbp, &testdata.Comment{ArticleID: article.ID, Body: article.Title})
+		expected[article.ID] = article.Title
+	}
+
+	it, err := yaorm.GenericSelectIterator(dbp, testdata.NewArticleFilter().Comments(
+		testdata.NewCommentFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	defer it.Close()
+	it.WithWindow(2)
+	found := map[int64]string{}
+	for it.Next() {
+		article := it.Model().(*testdata.Article)
+		assert.Equal(t, dbp, article.GetDBP())
+		if assert.Len(t, article.Comments, 1) {
+			assert.Equal(t, article.Title, article.Comments[0].Body)
+			assert.Equal(t, []string{"load"}, article.Comments[0].Events)
+		}
+		found[article.ID] = article.Title
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, expected, found)
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Close())
+}
+
+func TestGenericSelectIter(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		saveModel(t, dbp, &testdata.Category{Name: fmt.Sprintf("category %d", i)})
+	}
+
+	count := 0
+	err = yaorm.GenericSelectIter(dbp, testdata.NewCategoryFilter(), func(m yaorm.Model) error {
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	count = 0
+	err = yaorm.GenericSelectIter(dbp, testdata.NewCategoryFilter().Name(yaormfilter.Equals("category 1")), func(m yaorm.Model) error {
+		count++
+		assert.Equal(t, "category 1", m.(*testdata.Category).Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count = 0
+	err = yaorm.GenericSelectIter(dbp, testdata.NewCategoryFilter(), func(m yaorm.Model) error {
+		count++
+		return errors.Errorf("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,281 @@
+package yaorm
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// joinloadSeparator separates the alias of a joined table from the column in the selected column names
+const joinloadSeparator = "__"
+
+// joinload is a to-one relation loaded from the join rendered by the filter
+type joinload struct {
+	// alias of the joined table in the statement
+	alias string
+	table *Table
+	// index of the relation field in the parent model
+	fieldIndex int
+	columns    []string
+}
+
+// joinloads returns the to-one relations of the root model requested to be loaded with a join by the filter
+func joinloads(table *Table, f yaormfilter.Filter) ([]joinload, error) {
+	loads := []joinload{}
+	valueF := tools.GetNonPtrValue(f)
+	if !valueF.IsValid() {
+		return loads, nil
+	}
+	modelType := reflect.TypeOf(table.model).Elem()
+	st := valueF.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		filterData, ok := field.Tag.Lookup("filter")
+		if !ok || filterData == "-" {
+			continue
+		}
+		loadData, ok := field.Tag.Lookup("filterload")
+		if !ok || loadData == "-" {
+			continue
+		}
+		joined, ok := valueF.Field(i).Interface().(yaormfilter.Filter)
+		if !ok || valueF.Field(i).IsNil() || !shouldJoinload(joined) {
+			continue
+		}
+		tagData := strings.Split(filterData, ",")
+		if !isJoinTag(tagData) {
+			return nil, errors.Errorf("Filter field %s must be joined to be joinloaded", field.Name)
+		}
+		fieldIndex := -1
+		for j := 0; j < modelType.NumField(); j++ {
+			modelLoadData, ok := modelType.Field(j).Tag.Lookup("filterload")
+			if ok && strings.Split(modelLoadData, ",")[0] == loadData {
+				fieldIndex = j
+				break
+			}
+		}
+		if fieldIndex < 0 {
+			return nil, errors.Errorf("Cannot find tag filterload:%v in model struct", loadData)
+		}
+		if fieldType := modelType.Field(fieldIndex).Type; fieldType.Kind() != reflect.Ptr || fieldType.Elem().Kind() != reflect.Struct {
+			return nil, errors.Errorf("Field %s of model %s is not a to-one relation and cannot be joinloaded", modelType.Field(fieldIndex).Name, table.Name())
+		}
+		joinedTable, err := GetTableByFilter(joined)
+		if err != nil {
+			return nil, err
+		}
+		sf := buildSelectColumns{
+			loadColumns:     joined.GetLoadColumns(),
+			dontLoadColumns: joined.GetDontLoadColumns(),
+		}
+		loads = append(loads, joinload{
+			alias:      joinAlias(table.Name(), joined, -1),
+			table:      joinedTable,
+			fieldIndex: fieldIndex,
+			columns:    sf.reduce(joinedTable.Fields()),
+		})
+	}
+	return loads, nil
+}
+
+func shouldJoinload(f yaormfilter.Filter) bool {
+	for _, option := range f.GetSelectOptions() {
+		if option == yaormfilter.RequestOptions.Joinload {
+			return true
+		}
+	}
+	return false
+}
+
+// selectJoinloadColumns adds the columns of the joinloaded tables to the statement, prefixed by their alias
+func selectJoinloadColumns(dbp DBProvider, statement squirrel.SelectBuilder, loads []joinload) squirrel.SelectBuilder {
+	for _, load := range loads {
+		for _, column := range load.columns {
+			statement = statement.Column(fmt.Sprintf(
+				"%s.%s AS %s",
+				dbp.EscapeValue(load.alias),
+				dbp.EscapeValue(column),
+				dbp.EscapeValue(load.alias+joinloadSeparator+column),
+			))
+		}
+	}
+	return statement
+}
+
+// rowScanner scans the selected rows into models, and their joinloaded relations
+type rowScanner struct {
+	dbp   DBProvider
+	table *Table
+	loads []joinload
+	// for each column, the joinload it belongs to (-1 for the root model) and the index of the field
+	columnLoads  []int
+	columnFields []int
+	columnIsKey  []bool
+}
+
+func newRowScanner(dbp DBProvider, table *Table, loads []joinload, columns []string) (*rowScanner, error) {
+	s := &rowScanner{dbp: dbp, table: table, loads: loads}
+	for _, column := range columns {
+		load, fieldTable, name := -1, table, column
+		if parts := strings.SplitN(column, joinloadSeparator, 2); len(parts) == 2 && parts[0] == countColumnPrefix {
+			// the count of a relation, scanned into the field declared with its count tag
+			r, err := getCountRelation(dbp, table, parts[1])
+			if err != nil {
+				return nil, err
+			}
+			s.columnLoads = append(s.columnLoads, -1)
+			s.columnFields = append(s.columnFields, r.fieldIndex)
+			s.columnIsKey = append(s.columnIsKey, false)
+			continue
+		} else if len(parts) == 2 {
+			for i := range loads {
+				if loads[i].alias == parts[0] {
+					load, fieldTable, name = i, loads[i].table, parts[1]
+				}
+			}
+		}
+		idx := fieldTable.FieldIndex(name)
+		if idx < 0 {
+			return nil, errors.Errorf("Cannot find field for column %s in model %s", column, fieldTable.Name())
+		}
+		_, isKey := fieldTable.KeyFields()[name]
+		s.columnLoads = append(s.columnLoads, load)
+		s.columnFields = append(s.columnFields, idx)
+		s.columnIsKey = append(s.columnIsKey, isKey)
+	}
+	return s, nil
+}
+
+// scan scans the current row into m, and the extra columns selected after the ones of the scanner into extra
+// the columns of the joined tables are scanned into pointers, so that a relation missing from a left join stays nil
+func (s *rowScanner) scan(rows *sql.Rows, m Model, extra ...interface{}) error {
+	reflectedM := tools.GetNonPtrValue(m)
+	dest := make([]interface{}, len(s.columnFields))
+	for i, idx := range s.columnFields {
+		if s.columnLoads[i] < 0 {
+			dest[i] = reflectedM.Field(idx).Addr().Interface()
+			continue
+		}
+		fieldType := reflect.TypeOf(s.loads[s.columnLoads[i]].table.model).Elem().Field(idx).Type
+		dest[i] = reflect.New(reflect.PtrTo(fieldType)).Interface()
+	}
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return err
+	}
+	m.SetDBP(s.dbp)
+	for l, load := range s.loads {
+		var related Model
+		for i, idx := range s.columnFields {
+			if s.columnLoads[i] != l {
+				continue
+			}
+			value := reflect.ValueOf(dest[i]).Elem()
+			if value.IsNil() {
+				if s.columnIsKey[i] {
+					// primary key is NULL, the relation does not exist
+					related = nil
+					break
+				}
+				continue
+			}
+			if related == nil {
+				related, err = load.table.NewModel()
+				if err != nil {
+					return err
+				}
+			}
+			tools.GetNonPtrValue(related).Field(idx).Set(value.Elem())
+		}
+		if related == nil {
+			continue
+		}
+		related.SetDBP(s.dbp)
+		reflectedM.Field(load.fieldIndex).Set(reflect.ValueOf(related))
+		err = s.dbp.afterLoad(related)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryRows runs the select statement through the executor hooks, and calls fn on every row
+// one selects the SelectOne hooks instead of the Select ones
+func queryRows(dbp DBProvider, one bool, query string, args []interface{}, fn func(*sql.Rows) error) error {
+	executor, err := sqlExecutor(dbp)
+	if err != nil {
+		return err
+	}
+	hook := executor.db.ExecutorHook()
+	if one {
+		hook.BeforeSelectOne(executor.ctx, query, args...)
+		defer hook.AfterSelectOne(executor.ctx, query, args...)
+	} else {
+		hook.BeforeSelect(executor.ctx, query, args...)
+		defer hook.AfterSelect(executor.ctx, query, args...)
+	}
+	rows, err := executor.SqlExecutor.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = fn(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// selectOneJoinloaded selects the row into m, along with its joinloaded relations
+func selectOneJoinloaded(dbp DBProvider, table *Table, loads []joinload, m Model, query string, args []interface{}) error {
+	models, err := scanJoinloaded(dbp, true, table, loads, query, args)
+	if err != nil {
+		return err
+	}
+	switch len(models) {
+	case 0:
+		return sql.ErrNoRows
+	case 1:
+		tools.GetNonPtrValue(m).Set(tools.GetNonPtrValue(models[0]))
+		return nil
+	}
+	return errors.Errorf("multiple rows returned for: %s - %v", query, args)
+}
+
+// selectAllJoinloaded selects all the rows into new models, along with their joinloaded relations
+func selectAllJoinloaded(dbp DBProvider, table *Table, loads []joinload, query string, args []interface{}) ([]Model, error) {
+	return scanJoinloaded(dbp, false, table, loads, query, args)
+}
+
+func scanJoinloaded(dbp DBProvider, one bool, table *Table, loads []joinload, query string, args []interface{}) ([]Model, error) {
+	models := []Model{}
+	var scanner *rowScanner
+	err := queryRows(dbp, one, query, args, func(rows *sql.Rows) error {
+		if scanner == nil {
+			columns, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			scanner, err = newRowScanner(dbp, table, loads, columns)
+			if err != nil {
+				return err
+			}
+		}
+		m, err := table.NewModel()
+		if err != nil {
+			return err
+		}
+		models = append(models, m)
+		return scanner.scan(rows, m)
+	})
+	return models, err
+}
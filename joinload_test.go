@@ -0,0 +1,71 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinload(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	first := &testdata.Category{Name: "first"}
+	saveModel(t, dbp, first)
+	second := &testdata.Category{Name: "second"}
+	saveModel(t, dbp, second)
+	firstPost := &testdata.Post{Subject: "first post", CategoryID: first.ID}
+	saveModel(t, dbp, firstPost)
+	secondPost := &testdata.Post{Subject: "second post", CategoryID: second.ID}
+	saveModel(t, dbp, secondPost)
+
+	category := testdata.NewCategoryFilter()
+	category.Joinload()
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(category))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 2) {
+		for _, m := range models {
+			post := m.(*testdata.Post)
+			assert.Equal(t, dbp, post.GetDBP())
+			if assert.NotNil(t, post.Category) {
+				assert.Equal(t, post.CategoryID, post.Category.ID)
+				assert.Equal(t, dbp, post.Category.GetDBP())
+			}
+		}
+	}
+
+	category = testdata.NewCategoryFilter().Name(yaormfilter.Equals("second"))
+	category.Joinload()
+	category.LoadColumns("id", "name")
+	m, err := yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().Category(category))
+	assert.NoError(t, err)
+	post := m.(*testdata.Post)
+	assert.Equal(t, secondPost.ID, post.ID)
+	if assert.NotNil(t, post.Category) {
+		assert.Equal(t, "second", post.Category.Name)
+		assert.True(t, post.Category.CreatedAt.IsZero())
+	}
+
+	// the relation is loaded with a left join when the filter has no condition on it
+	orphan := &testdata.Post{Subject: "orphan post"}
+	saveModel(t, dbp, orphan)
+	category = testdata.NewCategoryFilter()
+	category.Joinload()
+	m, err = yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(orphan.ID)).Category(category))
+	assert.NoError(t, err)
+	assert.Nil(t, m.(*testdata.Post).Category)
+	models, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Category(category))
+	assert.NoError(t, err)
+	assert.Len(t, models, 3)
+
+	children := testdata.NewPostFilter()
+	children.Joinload()
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().ChildrenPosts(children))
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package yaorm
+
+import (
+	"fmt"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// checkLockOptions checks the locking options of a filter of the table do not both fail on and skip the locked rows
+func checkLockOptions(tableName string, options []yaormfilter.RequestOption) error {
+	noWait, skipLocked := false, false
+	for _, option := range options {
+		switch option {
+		case yaormfilter.RequestOptions.NoWait:
+			noWait = true
+		case yaormfilter.RequestOptions.SkipLocked:
+			skipLocked = true
+		}
+	}
+	if noWait && skipLocked {
+		return errors.Errorf("Filter of table %s cannot both fail on and skip the locked rows", tableName)
+	}
+	return nil
+}
+
+// lockClause returns the locking clause of the rows of the table selected under the alias, as requested by the options,
+// or an empty string when no lock is requested or the database does not provide it
+// NOWAIT and SKIP LOCKED are only rendered along with a lock the database provides
+// the options must have been checked with checkLockOptions, it panics otherwise
+func lockClause(dbp DBProvider, alias string, options []yaormfilter.RequestOption) string {
+	if err := checkLockOptions(alias, options); err != nil {
+		panic(err)
+	}
+	mode := ""
+	for _, option := range options {
+		switch option {
+		case yaormfilter.RequestOptions.SelectForUpdate:
+			if dbp.HasCapacity(DatabaseCapacitySelectForUpdate) {
+				mode = "FOR UPDATE"
+			}
+		case yaormfilter.RequestOptions.SelectForShare:
+			if dbp.HasCapacity(DatabaseCapacitySelectForShare) {
+				mode = "FOR SHARE"
+			}
+		}
+	}
+	if mode == "" {
+		return ""
+	}
+	clause := fmt.Sprintf("%s OF %s", mode, dbp.EscapeValue(alias))
+	for _, option := range options {
+		switch option {
+		case yaormfilter.RequestOptions.NoWait:
+			if dbp.HasCapacity(DatabaseCapacityNoWait) {
+				clause += " NOWAIT"
+			}
+		case yaormfilter.RequestOptions.SkipLocked:
+			if dbp.HasCapacity(DatabaseCapacitySkipLocked) {
+				clause += " SKIP LOCKED"
+			}
+		}
+	}
+	return clause
+}
+
+// ClaimBatch implements the job queue pattern: inside a transaction, it selects up to n rows matching the filter,
+// locked FOR UPDATE SKIP LOCKED so that concurrent workers claim distinct rows without waiting for each other,
+// and calls fn with their models. The transaction is committed when fn succeeds, and rolled back otherwise,
+// releasing the rows for another worker. fn is not called when no row is available.
+// Returns the number of rows claimed, or an error when the database cannot skip the locked rows
+// (see DatabaseCapacitySkipLocked)
+// panics if filter or dbp is nil
+func ClaimBatch(dbp DBProvider, filter yaormfilter.Filter, n uint64, fn func(models []Model) error) (int, error) {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	for _, option := range filter.GetSelectOptions() {
+		switch option {
+		case yaormfilter.RequestOptions.SelectForUpdate, yaormfilter.RequestOptions.SelectForShare:
+			return 0, errors.Errorf("Filter of table %s already locks the rows it selects, it cannot be claimed", table.Name())
+		}
+	}
+	if !dbp.HasCapacity(DatabaseCapacitySkipLocked) {
+		return 0, errors.Errorf("Database does not skip locked rows, rows of table %s cannot be claimed", table.Name())
+	}
+	claimed := 0
+	err = dbp.RunInTransaction(func() error {
+		models, err := selectAll(dbp, filter, func(statement squirrel.SelectBuilder) squirrel.SelectBuilder {
+			statement = statement.Limit(n)
+			if lock := lockClause(dbp, table.Name(), []yaormfilter.RequestOption{
+				yaormfilter.RequestOptions.SelectForUpdate, yaormfilter.RequestOptions.SkipLocked,
+			}); lock != "" {
+				statement = statement.Suffix(lock)
+			}
+			return statement
+		})
+		if err != nil || len(models) == 0 {
+			return err
+		}
+		claimed = len(models)
+		return fn(models)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return claimed, nil
+}
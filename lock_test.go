@@ -0,0 +1,164 @@
+package yaorm_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimBatch(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		saveModel(t, dbp, &testdata.Task{Title: fmt.Sprintf("task %d", i)})
+	}
+	consume := func(models []yaorm.Model) error {
+		for _, m := range models {
+			if _, err := yaorm.GenericDelete(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f := testdata.NewTaskFilter()
+	f.ForUpdate()
+	_, err = yaorm.ClaimBatch(dbp, f, 2, consume)
+	assert.EqualError(t, err, "Filter of table task already locks the rows it selects, it cannot be claimed")
+
+	if !dbp.HasCapacity(yaorm.DatabaseCapacitySkipLocked) {
+		claimed, err := yaorm.ClaimBatch(dbp, testdata.NewTaskFilter(), 2, func(models []yaorm.Model) error {
+			t.Error("no task should be claimed")
+			return nil
+		})
+		assert.EqualError(t, err, "Database does not skip locked rows, rows of table task cannot be claimed")
+		assert.Equal(t, 0, claimed)
+		return
+	}
+
+	// a failing batch is released for the next worker
+	claimed, err := yaorm.ClaimBatch(dbp, testdata.NewTaskFilter(), 2, func(models []yaorm.Model) error {
+		if err := consume(models); err != nil {
+			return err
+		}
+		return fmt.Errorf("worker failure")
+	})
+	assert.EqualError(t, err, "worker failure")
+	assert.Equal(t, 0, claimed)
+	count, err := yaorm.GenericCount(dbp, testdata.NewTaskFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	claimed, err = yaorm.ClaimBatch(dbp, testdata.NewTaskFilter(), 2, consume)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, claimed)
+	claimed, err = yaorm.ClaimBatch(dbp, testdata.NewTaskFilter(), 2, consume)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+	claimed, err = yaorm.ClaimBatch(dbp, testdata.NewTaskFilter(), 2, func(models []yaorm.Model) error {
+		t.Error("no task should be claimed")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, claimed)
+
+}
+
+func TestSelectLockingOptions(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	saveModel(t, dbp, &testdata.Task{Title: "task"})
+
+	err = dbp.RunInTransaction(func() error {
+		f := testdata.NewTaskFilter().ID(yaormfilter.Gt(int64(0)))
+		f.ForShare()
+		f.NoWait()
+		models, err := yaorm.GenericSelectAll(dbp, f)
+		assert.Len(t, models, 1)
+		if err != nil {
+			return err
+		}
+		f = testdata.NewTaskFilter()
+		f.ForUpdate()
+		f.SkipLocked()
+		models, err = yaorm.GenericSelectAll(dbp, f)
+		assert.Len(t, models, 1)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, dbp.HasCapacity(yaorm.DatabaseCapacitySelectForUpdate), dbp.CanSelectForUpdate())
+}
+
+func TestSelectLockingOptions_Dialects(t *testing.T) {
+	for _, dialect := range []struct {
+		system  yaorm.DMS
+		dsn     string
+		version []int
+		update  string
+		share   string
+	}{
+		{yaorm.DatabasePostgreSQL, "postgres://127.0.0.1:1/none?sslmode=disable", []int{12}, `FOR UPDATE OF "task" SKIP LOCKED`, `FOR SHARE OF "task" NOWAIT`},
+		{yaorm.DatabasePostgreSQL, "postgres://127.0.0.1:1/none?sslmode=disable", []int{9, 4}, `FOR UPDATE OF "task"`, `FOR SHARE OF "task" NOWAIT`},
+		{yaorm.DatabaseMySQL, "none@tcp(127.0.0.1:1)/none", []int{8, 0, 23}, "FOR UPDATE OF `task` SKIP LOCKED", "FOR SHARE OF `task` NOWAIT"},
+		{yaorm.DatabaseMySQL, "none@tcp(127.0.0.1:1)/none", []int{5, 7}, "", ""},
+		{yaorm.DatabaseSqlite3, fmt.Sprintf("/tmp/yaorm_test_%d.sqlite", rand.Int()), []int{3, 45}, "", ""},
+	} {
+		err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+			Name:         "test",
+			DSN:          dialect.dsn,
+			System:       dialect.system,
+			ExecutorHook: &customExecutorHookForTesting{},
+		})
+		assert.NoError(t, err)
+		dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+		assert.NoError(t, err)
+		yaorm.SetServerVersion(dbp, dialect.version...)
+
+		for _, options := range []struct {
+			expected string
+			lock     func(f *testdata.TaskFilter)
+		}{
+			{dialect.update, func(f *testdata.TaskFilter) { f.ForUpdate(); f.SkipLocked() }},
+			{dialect.share, func(f *testdata.TaskFilter) { f.ForShare(); f.NoWait() }},
+		} {
+			f := testdata.NewTaskFilter()
+			options.lock(f)
+			query_ = ""
+			yaorm.GenericSelectAll(dbp, f)
+			assert.NotEmpty(t, query_)
+			if options.expected == "" {
+				assert.NotContains(t, query_, " FOR ", "%v %v", dialect.system, dialect.version)
+			} else {
+				assert.True(t, strings.HasSuffix(query_, options.expected), "%v %v: %s", dialect.system, dialect.version, query_)
+			}
+		}
+
+		f := testdata.NewTaskFilter()
+		f.ForUpdate()
+		f.NoWait()
+		f.SkipLocked()
+		query_ = ""
+		_, err = yaorm.GenericSelectAll(dbp, f)
+		assert.EqualError(t, err, "Filter of table task cannot both fail on and skip the locked rows")
+		assert.Equal(t, "", query_)
+
+		yaorm.UnregisterDB("test")
+		if dialect.system == yaorm.DatabaseSqlite3 {
+			os.Remove(dialect.dsn)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
 	"github.com/geoffreybauduin/yaorm/tools"
 	"github.com/geoffreybauduin/yaorm/yaormfilter"
 	"github.com/juju/errors"
@@ -31,6 +33,31 @@ type Model interface {
 	DBHookBeforeDelete() error
 }
 
+// AfterInsertHook can be implemented by a model to run code once it has been inserted
+type AfterInsertHook interface {
+	// DBHookAfterInsert is a hook called after a successful DB insertion
+	DBHookAfterInsert() error
+}
+
+// AfterUpdateHook can be implemented by a model to run code once it has been updated
+type AfterUpdateHook interface {
+	// DBHookAfterUpdate is a hook called after a successful DB update
+	DBHookAfterUpdate() error
+}
+
+// AfterDeleteHook can be implemented by a model to run code once it has been deleted
+type AfterDeleteHook interface {
+	// DBHookAfterDelete is a hook called after a successful DB delete, when a row has been deleted
+	DBHookAfterDelete() error
+}
+
+// AfterLoadHook can be implemented by a model to run code once it has been loaded
+type AfterLoadHook interface {
+	// DBHookAfterLoad is a hook called after the model and the relations requested
+	// by the filter have been loaded
+	DBHookAfterLoad() error
+}
+
 // DatabaseModel is the struct every model should compose
 type DatabaseModel struct {
 	dbp DBProvider `db:"-"`
@@ -102,6 +129,10 @@ func GenericCount(dbp DBProvider, filter yaormfilter.Filter) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	err = checkFilter(dbp, table, filter)
+	if err != nil {
+		return 0, err
+	}
 	statement, err := buildCount(dbp, table)
 	if err != nil {
 		return 0, err
@@ -119,6 +150,61 @@ func GenericCount(dbp DBProvider, filter yaormfilter.Filter) (uint64, error) {
 	return counter.Count, nil
 }
 
+// GenericExists returns whether at least one row matches the filter
+// panics if filter or dbp is nil
+func GenericExists(dbp DBProvider, filter yaormfilter.Filter) (bool, error) {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	err = checkFilter(dbp, table, filter)
+	if err != nil {
+		return false, err
+	}
+	statement := apply(buildExists(dbp, table), filter, dbp).Limit(1)
+	query, params, err := statement.ToSql()
+	if err != nil {
+		return false, err
+	}
+	var exists int64
+	err = dbp.DB().SelectOne(&exists, query, params...)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GenericPluck selects only one column of the rows matching the filter, into dest
+// which must be a pointer to a slice of the type of the column (like *[]int64)
+// panics if filter or dbp is nil
+func GenericPluck(dbp DBProvider, filter yaormfilter.Filter, column string, dest interface{}) error {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("Destination %T must be a pointer to a slice", dest)
+	}
+	err = checkFilter(dbp, table, filter)
+	if err != nil {
+		return err
+	}
+	statement, err := buildPluck(dbp, table, filter, column)
+	if err != nil {
+		return err
+	}
+	statement = apply(statement, filter, dbp)
+	query, params, err := statement.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = dbp.DB().Select(dest, query, params...)
+	return err
+}
+
 // GenericSelectOne selects one row in the database
 // panics if filter or dbp is nil
 func GenericSelectOne(dbp DBProvider, filter yaormfilter.Filter) (Model, error) {
@@ -179,12 +265,28 @@ func GenericSelectOneWithModel(dbp DBProvider, filter yaormfilter.Filter, m Mode
 	if err != nil {
 		return err
 	}
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return err
+	}
+	loads, err := joinloads(table, filter)
+	if err != nil {
+		return err
+	}
+	statement, err = selectCountColumns(dbp, selectJoinloadColumns(dbp, statement, loads), table, filter)
+	if err != nil {
+		return err
+	}
 	statement = apply(statement, filter, dbp)
 	query, params, err := statement.ToSql()
 	if err != nil {
 		return err
 	}
-	err = dbp.DB().SelectOne(m, query, params...)
+	if len(loads) > 0 || hasCounts(filter) {
+		err = selectOneJoinloaded(dbp, table, loads, m, query, params)
+	} else {
+		err = dbp.DB().SelectOne(m, query, params...)
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return errors.NotFoundf(formatTablenameFromError(m))
@@ -192,15 +294,58 @@ func GenericSelectOneWithModel(dbp DBProvider, filter yaormfilter.Filter, m Mode
 		return err
 	}
 	m.SetDBP(dbp)
-	if filter != nil {
-		err = finishSelect(dbp, m, filter)
+	return finishSelect(dbp, m, filter)
+}
+
+// GenericGet selects the row having the provided primary key values into the model,
+// the values are provided in the order of the keys of the table (see Table.WithKeys)
+// panics if m or dbp is nil
+func GenericGet(dbp DBProvider, m Model, pkValues ...interface{}) error {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return err
 	}
-	return err
+	keys := table.Keys()
+	if len(pkValues) != len(keys) {
+		return errors.Errorf("Table %s has %d primary keys %v, %d values provided", table.Name(), len(keys), keys, len(pkValues))
+	}
+	filter, err := table.NewFilter()
+	if err != nil {
+		return err
+	}
+	statement, err := buildSelect(dbp, m, buildSelectColumns{})
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		statement = statement.Where(squirrel.Eq{
+			fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(key)): pkValues[i],
+		})
+	}
+	statement = apply(statement, filter, dbp)
+	query, params, err := statement.ToSql()
+	if err != nil {
+		return err
+	}
+	err = dbp.DB().SelectOne(m, query, params...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NotFoundf(formatTablenameFromError(m))
+		}
+		return err
+	}
+	m.SetDBP(dbp)
+	return finishSelect(dbp, m, filter)
 }
 
 // GenericSelectAll selects all rows in the database
 // panics if filter or dbp is nil
 func GenericSelectAll(dbp DBProvider, filter yaormfilter.Filter) ([]Model, error) {
+	return selectAll(dbp, filter, nil)
+}
+
+// selectAll selects all rows matching the filter, alter completing the statement built from the filter when not nil
+func selectAll(dbp DBProvider, filter yaormfilter.Filter, alter func(statement squirrel.SelectBuilder) squirrel.SelectBuilder) ([]Model, error) {
 	table, err := GetTableByFilter(filter)
 	if err != nil {
 		return nil, err
@@ -216,12 +361,31 @@ func GenericSelectAll(dbp DBProvider, filter yaormfilter.Filter) ([]Model, error
 	if err != nil {
 		return nil, err
 	}
-	sm, _ := table.NewSlicePtr()
+	loads, err := joinloads(table, filter)
+	if err != nil {
+		return nil, err
+	}
+	statement, err = selectCountColumns(dbp, selectJoinloadColumns(dbp, statement, loads), table, filter)
+	if err != nil {
+		return nil, err
+	}
 	statement = apply(statement, filter, dbp)
+	if alter != nil {
+		statement = alter(statement)
+	}
 	query, params, err := statement.ToSql()
 	if err != nil {
 		return nil, err
 	}
+	if len(loads) > 0 || hasCounts(filter) {
+		models, err := selectAllJoinloaded(dbp, table, loads, query, params)
+		if err != nil {
+			return nil, err
+		}
+		err = finishSelect(dbp, models, filter)
+		return models, err
+	}
+	sm, _ := table.NewSlicePtr()
 	_, err = dbp.DB().Select(sm, query, params...)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -236,9 +400,7 @@ func GenericSelectAll(dbp DBProvider, filter yaormfilter.Filter) ([]Model, error
 		m.SetDBP(dbp)
 		models = append(models, m)
 	}
-	if filter != nil {
-		err = finishSelect(dbp, models, filter)
-	}
+	err = finishSelect(dbp, models, filter)
 	return models, err
 }
 
@@ -324,25 +486,83 @@ func genericSaveOnePK(m Model, keys map[string]int) error {
 }
 
 // GenericUpdate updates the provided model in the database
+// Fields tagged with `yaorm:"autoupdatetime"` are set to the current time before the hook is called
+// If the table has a version field, the update only applies to the loaded version, and
+// an error matching ErrStaleObject is returned if the row has been modified in the meantime
 // panics if model is nil or not linked to dbp
 func GenericUpdate(m Model) error {
-	err := m.DBHookBeforeUpdate()
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return err
+	}
+	restore := setTimestamps(table, m, table.autoUpdateTime, m.GetDBP().Now())
+	err = m.DBHookBeforeUpdate()
 	if err != nil {
+		restore()
 		return err
 	}
 	_, err = m.GetDBP().DB().Update(m)
-	return err
+	if err != nil {
+		restore()
+		return err
+	}
+	if hook, ok := m.(AfterUpdateHook); ok {
+		return hook.DBHookAfterUpdate()
+	}
+	return nil
 }
 
 // GenericInsert inserts the provided model in the database
+// Fields tagged with `yaorm:"autocreatetime"` or `yaorm:"autoupdatetime"` are set to the current time
+// before the hook is called
 // panics if model is nil or not linked to dbp
 func GenericInsert(m Model) error {
-	err := m.DBHookBeforeInsert()
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return err
+	}
+	fields := append(append([]string{}, table.autoCreateTime...), table.autoUpdateTime...)
+	restore := setTimestamps(table, m, fields, m.GetDBP().Now())
+	err = m.DBHookBeforeInsert()
 	if err != nil {
+		restore()
 		return err
 	}
 	err = m.GetDBP().DB().Insert(m)
-	return err
+	if err != nil {
+		restore()
+		return err
+	}
+	if hook, ok := m.(AfterInsertHook); ok {
+		return hook.DBHookAfterInsert()
+	}
+	return nil
+}
+
+// setTimestamps sets the provided time on every field, whether it is a time.Time or a *time.Time
+// Returns a function restoring the previous values of the fields, when the write fails
+func setTimestamps(table *Table, m Model, fields []string, now time.Time) func() {
+	reflectedM := tools.GetNonPtrValue(m)
+	previous := make([]reflect.Value, len(fields))
+	for i, field := range fields {
+		value := reflectedM.Field(table.FieldIndex(field))
+		previous[i] = reflect.New(value.Type()).Elem()
+		previous[i].Set(value)
+	}
+	for _, field := range fields {
+		value := reflectedM.Field(table.FieldIndex(field))
+		if value.Kind() == reflect.Ptr {
+			t := now
+			value.Set(reflect.ValueOf(&t))
+		} else {
+			value.Set(reflect.ValueOf(now))
+		}
+	}
+	return func() {
+		for i, field := range fields {
+			reflectedM.Field(table.FieldIndex(field)).Set(previous[i])
+		}
+	}
 }
 
 func formatTablenameFromError(m Model) string {
@@ -375,8 +595,32 @@ func filterShouldSubqueryload(filterFound reflect.Value) bool {
 	return false
 }
 
+// finishSelect loads the relations requested by the filter on the selected model(s),
+// and then calls their DBHookAfterLoad hook, the ones of the loaded relations being called
+// once the whole relation tree has been loaded
 func finishSelect(dbp DBProvider, m interface{}, f yaormfilter.Filter) error {
+	if f != nil {
+		release := dbp.beginLoadingRelations()
+		err := loadRelations(dbp, m, f)
+		loaded := release()
+		if err != nil {
+			return err
+		}
+		for _, models := range loaded {
+			err = callAfterLoadHooks(models)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return dbp.afterLoad(m)
+}
+
+// loadRelations subqueryloads the relations requested by the filter on the model(s)
+// the relations of the loaded models are loaded recursively, using the nested filters
+func loadRelations(dbp DBProvider, m interface{}, f yaormfilter.Filter) error {
 	fkPerModel := map[string]map[interface{}][]reflect.Value{}
+	perParentFilters := map[string]yaormfilter.Filter{}
 	valueF := reflect.Indirect(reflect.ValueOf(f))
 	if !valueF.IsValid() {
 		return nil
@@ -397,9 +641,12 @@ func finishSelect(dbp DBProvider, m interface{}, f yaormfilter.Filter) error {
 			if err != nil {
 				return err
 			}
+			if perParent := perParentFilter(filterFound.Interface()); perParent != nil {
+				perParentFilters[relationKeyInModels(m, dbFieldData)] = perParent
+			}
 		}
 	}
-	d, err := subqueryload(dbp, fkPerModel)
+	d, err := subqueryload(dbp, fkPerModel, perParentFilters)
 	if err != nil {
 		return err
 	}
@@ -427,7 +674,7 @@ func finishSelect(dbp DBProvider, m interface{}, f yaormfilter.Filter) error {
 func finishSelectFromFilter(dbp DBProvider, m interface{}, f interface{}) error {
 	switch f.(type) {
 	case yaormfilter.Filter:
-		err := finishSelect(dbp, m, f.(yaormfilter.Filter))
+		err := loadRelations(dbp, m, f.(yaormfilter.Filter))
 		if err != nil {
 			return err
 		}
@@ -435,7 +682,7 @@ func finishSelectFromFilter(dbp DBProvider, m interface{}, f interface{}) error
 	case []yaormfilter.Filter:
 		arr := f.([]yaormfilter.Filter)
 		for _, arrElem := range arr {
-			err := finishSelect(dbp, m, arrElem)
+			err := loadRelations(dbp, m, arrElem)
 			if err != nil {
 				return err
 			}
@@ -462,10 +709,7 @@ func feedFkPerModel(m interface{}, fkPerModel map[string]map[interface{}][]refle
 			if len(tagData) < 2 {
 				return nil, errors.Errorf("tag 'filterload' %+v is invalid, must have at least 2 parts", tagData)
 			}
-			if len(tagData) == 3 {
-				// it's a reverse filter
-				tagData[0] = fmt.Sprintf("%s_per_%s", tagData[0], tagData[2])
-			}
+			tagData[0] = relationKey(tag)
 			idxFk, _ := getFieldInModel(m.(Model), "db", tagData[1])
 			if idxFk > -1 {
 				fkReflectedvalue := s.Field(idxFk)
@@ -493,6 +737,32 @@ func feedFkPerModel(m interface{}, fkPerModel map[string]map[interface{}][]refle
 	return fkPerModel, nil
 }
 
+// relationKey returns the key under which the models of the relation declared by the filterload tag are subqueryloaded
+func relationKey(tag string) string {
+	tagData := strings.Split(tag, ",")
+	if len(tagData) == 3 {
+		// it's a reverse filter
+		return fmt.Sprintf("%s_per_%s", tagData[0], tagData[2])
+	}
+	if relation, ok := parseThroughRelation(tag); ok {
+		return relation.key()
+	}
+	return tagData[0]
+}
+
+// relationKeyInModels returns the key of the relation to the table declared on the models, which may be a slice
+func relationKeyInModels(m interface{}, table string) string {
+	s := reflect.Indirect(reflect.ValueOf(m))
+	if s.Kind() == reflect.Slice {
+		if s.Len() == 0 {
+			return table
+		}
+		return relationKeyInModels(s.Index(0).Interface(), table)
+	}
+	_, tag := getFieldInModel(m.(Model), "filterload", table)
+	return relationKey(tag)
+}
+
 func getFieldInModel(m Model, tag, equals string) (int, string) {
 	st := reflect.Indirect(reflect.ValueOf(m)).Type()
 	for i := 0; i < st.NumField(); i++ {
@@ -510,11 +780,73 @@ func getFieldInModel(m Model, tag, equals string) (int, string) {
 }
 
 // GenericDelete will delete the provided model from its database
+// If the table uses soft delete, the row is only flagged as deleted, see GenericRestore
+// If the table has a version field, an error matching ErrStaleObject is returned if the row
+// has been modified in the meantime
+// The relations declared with Table.WithRelation are applied in the same transaction, and an error matching
+// ErrDeleteRestricted is returned if a restricted relation still references the model. Their changes are rolled
+// back when the model has no row left to delete
 // Returns the number of rows deleted
 func GenericDelete(m Model) (int64, error) {
 	err := m.DBHookBeforeDelete()
 	if err != nil {
 		return 0, err
 	}
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	if len(table.relations) > 0 {
+		dbp := m.GetDBP()
+		err = dbp.RunInTransaction(func() error {
+			err := applyDeleteRelations(dbp, table, m)
+			if err != nil {
+				return err
+			}
+			rows, err = deleteModel(table, m)
+			if err == nil && rows == 0 {
+				// the delete rules have been applied for a model which is not deleted, they must be rolled back
+				return errNothingDeleted
+			}
+			return err
+		})
+		if errors.Cause(err) == errNothingDeleted {
+			err = nil
+		}
+	} else {
+		rows, err = deleteModel(table, m)
+	}
+	if err != nil {
+		return rows, err
+	}
+	if hook, ok := m.(AfterDeleteHook); ok && rows > 0 {
+		return rows, hook.DBHookAfterDelete()
+	}
+	return rows, nil
+}
+
+// deleteModel removes the row of the model, or flags it as deleted if the table uses soft delete
+func deleteModel(table *Table, m Model) (int64, error) {
+	if table.SoftDeleteField() != "" {
+		deletedAt := m.GetDBP().Now()
+		return setSoftDeleteField(table, m, &deletedAt)
+	}
 	return m.GetDBP().DB().Delete(m)
 }
+
+// callAfterLoadHooks calls the DBHookAfterLoad hook of the model, or of every model of the slice
+func callAfterLoadHooks(m interface{}) error {
+	switch v := m.(type) {
+	case []Model:
+		for _, model := range v {
+			err := callAfterLoadHooks(model)
+			if err != nil {
+				return err
+			}
+		}
+	case AfterLoadHook:
+		return v.DBHookAfterLoad()
+	}
+	return nil
+}
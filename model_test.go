@@ -2,11 +2,14 @@ package yaorm_test
 
 import (
 	"context"
+	stderrors "errors"
 	"testing"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm"
 	"github.com/geoffreybauduin/yaorm/testdata"
 	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/go-gorp/gorp"
 	"github.com/juju/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -601,3 +604,331 @@ func TestGenericDelete_MultiplePrimaryKeys(t *testing.T) {
 	assert.Len(t, listTags, 1)
 	assert.Equal(t, tag2.ID, listTags[0].(*testdata.PostTag).TagID)
 }
+
+func TestGenericUpdate_Version(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	assert.Equal(t, int64(1), article.Version)
+
+	concurrent := &testdata.Article{ID: article.ID}
+	err = concurrent.Load(dbp)
+	assert.NoError(t, err)
+	concurrent.SetDBP(dbp)
+
+	article.Title = "first edition"
+	err = yaorm.GenericUpdate(article)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), article.Version)
+
+	concurrent.Title = "second edition"
+	err = yaorm.GenericUpdate(concurrent)
+	assert.Error(t, err)
+	assert.True(t, stderrors.Is(err, yaorm.ErrStaleObject))
+	assert.Equal(t, int64(1), concurrent.Version)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewArticleFilter().ID(yaormfilter.Equals(article.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, "first edition", found.(*testdata.Article).Title)
+	assert.Equal(t, int64(2), found.(*testdata.Article).Version)
+}
+
+func TestGenericDelete_Version(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+
+	stale := &testdata.Article{ID: article.ID, Title: article.Title, Version: article.Version}
+	stale.SetDBP(dbp)
+	err = yaorm.GenericUpdate(article)
+	assert.NoError(t, err)
+
+	_, err = yaorm.GenericDelete(stale)
+	assert.Error(t, err)
+	assert.True(t, stderrors.Is(err, yaorm.ErrStaleObject))
+
+	rows, err := yaorm.GenericDelete(article)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+}
+
+func TestGenericInsert_AutoTimestamps(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbp.SetClock(func() time.Time { return created })
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	assert.Equal(t, created, article.CreatedAt)
+	assert.Equal(t, created, article.UpdatedAt)
+
+	updated := created.Add(time.Hour)
+	dbp.SetClock(func() time.Time { return updated })
+	article.Title = "new title"
+	err = yaorm.GenericUpdate(article)
+	assert.NoError(t, err)
+	assert.Equal(t, created, article.CreatedAt)
+	assert.Equal(t, updated, article.UpdatedAt)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewArticleFilter().ID(yaormfilter.Equals(article.ID)))
+	assert.NoError(t, err)
+	assert.True(t, created.Equal(found.(*testdata.Article).CreatedAt))
+	assert.True(t, updated.Equal(found.(*testdata.Article).UpdatedAt))
+}
+
+func TestGenericInsert_AutoTimestampsRestoredOnError(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbp.SetClock(func() time.Time { return created })
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+
+	// queries fail once the underlying transaction has been closed behind the provider's back
+	closedDbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, closedDbp.Tx())
+	assert.NoError(t, closedDbp.DB().(*yaorm.SqlExecutor).SqlExecutor.(*gorp.Transaction).Commit())
+	closedDbp.SetClock(func() time.Time { return created.Add(time.Hour) })
+	failing := &testdata.Article{Title: "failing"}
+	failing.SetDBP(closedDbp)
+	err = yaorm.GenericInsert(failing)
+	assert.Error(t, err)
+	assert.True(t, failing.CreatedAt.IsZero())
+	assert.True(t, failing.UpdatedAt.IsZero())
+
+	dbp.SetClock(func() time.Time { return created.Add(time.Hour) })
+
+	article.Version++
+	err = yaorm.GenericUpdate(article)
+	assert.Error(t, err)
+	assert.Equal(t, created, article.CreatedAt)
+	assert.Equal(t, created, article.UpdatedAt)
+}
+
+func TestGenericHooks_After(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	comment := &testdata.Comment{ArticleID: article.ID, Body: "body"}
+	saveModel(t, dbp, comment)
+	comment.Body = "new body"
+	err = yaorm.GenericSave(comment)
+	assert.NoError(t, err)
+	_, err = yaorm.GenericDelete(comment)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"insert", "update", "delete"}, comment.Events)
+	_, err = yaorm.GenericRestore(comment)
+	assert.NoError(t, err)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewCommentFilter().ID(yaormfilter.Equals(comment.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"load"}, found.(*testdata.Comment).Events)
+
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewCommentFilter())
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		assert.Equal(t, []string{"load"}, models[0].(*testdata.Comment).Events)
+	}
+
+	loaded, err := yaorm.GenericSelectOne(dbp, testdata.NewArticleFilter().ID(yaormfilter.Equals(article.ID)).Comments(
+		testdata.NewCommentFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, loaded.(*testdata.Article).Comments, 1) {
+		assert.Equal(t, []string{"load"}, loaded.(*testdata.Article).Comments[0].Events)
+	}
+
+	// nothing deleted, no hook
+	missing := &testdata.Comment{ID: comment.ID + 1, ArticleID: article.ID}
+	missing.SetDBP(dbp)
+	rows, err := yaorm.GenericDelete(missing)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+	assert.Empty(t, missing.Events)
+}
+
+func TestGenericHooks_AfterLoadOnceRelationsLoaded(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	parent := &testdata.Post{Subject: "parent"}
+	saveModel(t, dbp, parent)
+	child := &testdata.Post{Subject: "child", ParentPostID: parent.ID}
+	saveModel(t, dbp, child)
+	saveModel(t, dbp, &testdata.PostMetadata{PostID: child.ID, Key: "key 1", Value: "value"})
+	saveModel(t, dbp, &testdata.PostMetadata{PostID: child.ID, Key: "key 2", Value: "value"})
+
+	// children are loaded by the loader of the post table, their metadata afterwards
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(parent.ID)).ChildrenPosts(
+		testdata.NewPostFilter().Metadata(testdata.NewPostMetadataFilter().Subqueryload()).Subqueryload(),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, found.(*testdata.Post).ChildrenPost, 1) {
+		assert.Len(t, found.(*testdata.Post).ChildrenPost[0].Metadata, 2)
+		assert.Equal(t, 2, found.(*testdata.Post).ChildrenPost[0].MetadataOnLoad)
+	}
+}
+
+func TestGenericGet(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	post := &testdata.Post{Subject: "subject", CategoryID: category.ID}
+	saveModel(t, dbp, post)
+	tag := &testdata.Tag{Tag: "tag"}
+	saveModel(t, dbp, tag)
+	posttag := &testdata.PostTag{TagID: tag.ID, PostID: post.ID}
+	saveModel(t, dbp, posttag)
+
+	found := &testdata.Category{}
+	err = yaorm.GenericGet(dbp, found, category.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "category", found.Name)
+	assert.Equal(t, dbp, found.GetDBP())
+
+	foundPostTag := &testdata.PostTag{}
+	err = yaorm.GenericGet(dbp, foundPostTag, post.ID, tag.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, post.ID, foundPostTag.PostID)
+	assert.Equal(t, tag.ID, foundPostTag.TagID)
+
+	err = yaorm.GenericGet(dbp, &testdata.PostTag{}, tag.ID, post.ID+10)
+	assert.True(t, errors.IsNotFound(err))
+	assert.EqualError(t, err, "PostTag not found")
+
+	err = yaorm.GenericGet(dbp, &testdata.PostTag{}, post.ID)
+	assert.EqualError(t, err, "Table post_tag has 2 primary keys [post_id tag_id], 1 values provided")
+
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	_, err = yaorm.GenericDelete(article)
+	assert.NoError(t, err)
+	err = yaorm.GenericGet(dbp, &testdata.Article{}, article.ID)
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestGenericExists(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	saveModel(t, dbp, &testdata.Post{Subject: "subject", CategoryID: category.ID})
+
+	exists, err := yaorm.GenericExists(dbp, testdata.NewPostFilter())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = yaorm.GenericExists(dbp, testdata.NewPostFilter().Category(
+		testdata.NewCategoryFilter().Name(yaormfilter.Equals("category")),
+	))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = yaorm.GenericExists(dbp, testdata.NewPostFilter().Category(
+		testdata.NewCategoryFilter().Name(yaormfilter.Equals("other")),
+	))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestGenericPluck(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	first := &testdata.Post{Subject: "first", CategoryID: category.ID}
+	saveModel(t, dbp, first)
+	second := &testdata.Post{Subject: "second", CategoryID: category.ID}
+	saveModel(t, dbp, second)
+
+	filter := testdata.NewPostFilter()
+	filter.SetOrderBy("id", yaormfilter.OrderingWays.Desc)
+	var ids []int64
+	err = yaorm.GenericPluck(dbp, filter, "id", &ids)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{second.ID, first.ID}, ids)
+
+	var subjects []string
+	err = yaorm.GenericPluck(dbp, testdata.NewPostFilter().Category(
+		testdata.NewCategoryFilter().Name(yaormfilter.Equals("category")),
+	).Subject(yaormfilter.Equals("first")), "subject", &subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first"}, subjects)
+
+	distinct := testdata.NewPostFilter()
+	distinct.Distinct()
+	var categoryIDs []int64
+	err = yaorm.GenericPluck(dbp, distinct, "category_id", &categoryIDs)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{category.ID}, categoryIDs)
+
+	// distinct values can only be ordered by the plucked column
+	distinct.OrderBy("category_id", yaormfilter.OrderingWays.Desc)
+	categoryIDs = nil
+	err = yaorm.GenericPluck(dbp, distinct, "category_id", &categoryIDs)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{category.ID}, categoryIDs)
+	distinct.OrderBy("id", yaormfilter.OrderingWays.Desc)
+	err = yaorm.GenericPluck(dbp, distinct, "category_id", &categoryIDs)
+	assert.Error(t, err)
+
+	err = yaorm.GenericPluck(dbp, testdata.NewPostFilter(), "unknown", &ids)
+	assert.Error(t, err)
+	err = yaorm.GenericPluck(dbp, testdata.NewPostFilter(), "id", ids)
+	assert.Error(t, err)
+}
+
+func TestGenericSelectAll_AutomaticSubqueryloader(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	author := &testdata.Author{Name: "author"}
+	saveModel(t, dbp, author)
+	book := &testdata.Book{AuthorID: author.ID, Title: "title"}
+	saveModel(t, dbp, book)
+
+	// neither author nor book declare a subqueryloader
+	m, err := yaorm.GenericSelectOne(dbp, testdata.NewBookFilter().Author(testdata.NewAuthorFilter().Subqueryload()))
+	assert.NoError(t, err)
+	if assert.NotNil(t, m.(*testdata.Book).Author) {
+		assert.Equal(t, author.ID, m.(*testdata.Book).Author.ID)
+	}
+	m, err = yaorm.GenericSelectOne(dbp, testdata.NewAuthorFilter().Books(testdata.NewBookFilter().Subqueryload()))
+	assert.NoError(t, err)
+	if assert.Len(t, m.(*testdata.Author).Books, 1) {
+		assert.Equal(t, book.ID, m.(*testdata.Author).Books[0].ID)
+	}
+}
@@ -0,0 +1,90 @@
+package yaorm
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+// projectionColumn matches the columns of a projection, like "post.subject" or "category.name AS category_name"
+var projectionColumn = regexp.MustCompile(`^\s*(?:(\w+)\.)?(\w+)(?:\s+(?i:AS)\s+(\w+))?\s*$`)
+
+// GenericSelectInto selects the provided columns of the rows matching the filter into dest,
+// which must be a pointer to a slice of structs (or of pointers to structs) mapping the columns with `db` tags
+// Columns are written as "alias.column", optionally followed by "AS name": the alias is either the root table,
+// the alias of a joined table (like "post_category"), or the name of a joined table if it is joined only once.
+// A column without alias belongs to the root table, and other expressions are selected as is.
+// Use ModelFilter.Join to join a table without filtering on it
+// panics if filter or dbp is nil
+func GenericSelectInto(dbp DBProvider, filter yaormfilter.Filter, dest interface{}, columns ...string) error {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("Destination %T must be a pointer to a slice", dest)
+	}
+	if len(columns) == 0 {
+		return errors.Errorf("No column to select into %T", dest)
+	}
+	err = checkFilter(dbp, table, filter)
+	if err != nil {
+		return err
+	}
+	aliases := map[string]string{table.Name(): table.Name()}
+	joinAliases(filter, table.Name(), aliases)
+	selected := make([]string, 0, len(columns))
+	for _, column := range columns {
+		c, err := projectColumn(dbp, table.Name(), aliases, column)
+		if err != nil {
+			return err
+		}
+		selected = append(selected, c)
+	}
+	statement := dbp.getStatementGenerator().Select(selected...).From(
+		fmt.Sprintf("%s AS %s", table.NameForQuery(dbp), dbp.EscapeValue(table.Name())),
+	)
+	statement = apply(statement, filter, dbp)
+	query, params, err := statement.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = dbp.DB().Select(dest, query, params...)
+	return err
+}
+
+// projectColumn escapes the column of a projection, resolving its alias
+func projectColumn(dbp DBProvider, root string, aliases map[string]string, column string) (string, error) {
+	matches := projectionColumn.FindStringSubmatch(column)
+	if matches == nil {
+		return column, nil
+	}
+	alias, name, as := matches[1], matches[2], matches[3]
+	if alias == "" {
+		alias = root
+	} else if _, ok := aliases[alias]; !ok {
+		resolved := ""
+		for candidate, tableName := range aliases {
+			if tableName != alias {
+				continue
+			}
+			if resolved != "" {
+				return "", errors.Errorf("Table %s is joined several times, use one of its aliases in column %s", alias, column)
+			}
+			resolved = candidate
+		}
+		if resolved == "" {
+			return "", errors.Errorf("Cannot find table %s joined by the filter for column %s", alias, column)
+		}
+		alias = resolved
+	}
+	c := fmt.Sprintf("%s.%s", dbp.EscapeValue(alias), dbp.EscapeValue(name))
+	if as != "" {
+		c = fmt.Sprintf("%s AS %s", c, dbp.EscapeValue(as))
+	}
+	return c, nil
+}
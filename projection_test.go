@@ -0,0 +1,52 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+type postSummary struct {
+	ID           int64  `db:"id"`
+	Subject      string `db:"subject"`
+	CategoryName string `db:"category_name"`
+}
+
+func TestGenericSelectInto(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	post := &testdata.Post{Subject: "subject", CategoryID: category.ID}
+	saveModel(t, dbp, post)
+
+	categoryFilter := testdata.NewCategoryFilter()
+	categoryFilter.Join()
+	var summaries []postSummary
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Category(categoryFilter), &summaries,
+		"post.id", "subject", "category.name AS category_name",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []postSummary{{ID: post.ID, Subject: "subject", CategoryName: "category"}}, summaries)
+
+	var pointers []*postSummary
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter().Category(
+		testdata.NewCategoryFilter().Name(yaormfilter.Equals("category")),
+	), &pointers, "post.id", "post_category.name AS category_name")
+	assert.NoError(t, err)
+	if assert.Len(t, pointers, 1) {
+		assert.Equal(t, "category", pointers[0].CategoryName)
+	}
+
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter(), &summaries, "category.name AS category_name")
+	assert.Error(t, err)
+	err = yaorm.GenericSelectInto(dbp, testdata.NewPostFilter(), summaries, "post.id")
+	assert.Error(t, err)
+}
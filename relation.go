@@ -0,0 +1,206 @@
+package yaorm
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/juju/errors"
+)
+
+// OnDelete is the action applied by GenericDelete on the rows of a related table referencing the deleted model
+type OnDelete string
+
+const (
+	// OnDeleteCascade deletes the related models with GenericDelete, calling their hooks and applying their own relations
+	// a table using soft delete refuses to cascade its delete to a related table which does not, as its rows
+	// would be removed while the model can still be restored
+	OnDeleteCascade OnDelete = "cascade"
+	// OnDeleteSetNull sets the foreign key of the related rows to NULL, without calling their hooks
+	// the foreign key field must be able to hold NULL, and the version of versioned related rows is incremented
+	// the related rows are not set back when the model is restored with GenericRestore
+	OnDeleteSetNull OnDelete = "setnull"
+	// OnDeleteRestrict refuses to delete the model while related rows reference it
+	OnDeleteRestrict OnDelete = "restrict"
+)
+
+var (
+	// ErrDeleteRestricted is matched, using errors.Is, by the error returned when a model cannot be deleted
+	// because rows of a relation declared with OnDeleteRestrict still reference it
+	ErrDeleteRestricted = errors.Errorf("Delete restricted")
+	// errNothingDeleted rolls back the delete rules applied by GenericDelete when the model has no row to delete
+	errNothingDeleted = errors.Errorf("Nothing deleted")
+)
+
+// DeleteRestrictedError is returned when rows of a relation declared with OnDeleteRestrict reference the deleted model
+type DeleteRestrictedError struct {
+	// Table is the name of the table of the model
+	Table string
+	// RelatedTable is the name of the table referencing the model
+	RelatedTable string
+	// Count is the number of rows referencing the model
+	Count int64
+}
+
+func (e *DeleteRestrictedError) Error() string {
+	return fmt.Sprintf("Delete restricted: %d rows of table %s reference table %s", e.Count, e.RelatedTable, e.Table)
+}
+
+// Is allows matching ErrDeleteRestricted with errors.Is
+func (e *DeleteRestrictedError) Is(target error) bool {
+	return target == ErrDeleteRestricted
+}
+
+// relation is a table whose rows reference the models of another table, declared with Table.WithRelation
+type relation struct {
+	table string
+	// field of the referenced model held by the fk of the related rows
+	field    string
+	fk       string
+	onDelete OnDelete
+}
+
+// relatedTable returns the table of the related rows, which may have been registered after the declaration
+func (r relation) relatedTable(dbp DBProvider, table *Table) (*Table, error) {
+	related, err := GetTable(dbp.dbName(), r.table)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkForeignKey(related)
+	if err != nil {
+		return nil, err
+	}
+	if r.onDelete == OnDeleteCascade && table.SoftDeleteField() != "" && related.SoftDeleteField() == "" {
+		return nil, errors.Errorf("Table %s uses soft delete, its delete cannot be cascaded to table %s which does not", table.Name(), related.Name())
+	}
+	return related, nil
+}
+
+// checkForeignKey checks that the fk exists in the related table, and that it can hold NULL when it is set to NULL
+func (r relation) checkForeignKey(related *Table) error {
+	idx := related.FieldIndex(r.fk)
+	if idx < 0 {
+		return errors.Errorf("Cannot find field %s in table %s", r.fk, r.table)
+	}
+	if r.onDelete != OnDeleteSetNull {
+		return nil
+	}
+	fieldType := related.reflectedType.Field(idx).Type
+	if fieldType.Kind() != reflect.Ptr && !fieldType.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem()) {
+		return errors.Errorf("Field %s of table %s cannot hold NULL, it must be a pointer to be set to NULL on delete", r.fk, r.table)
+	}
+	return nil
+}
+
+// where returns the condition matching the related rows, which have not been soft deleted
+func (r relation) where(dbp DBProvider, related *Table, value interface{}) squirrel.Sqlizer {
+	column := fmt.Sprintf("%s.%s", dbp.EscapeValue(related.Name()), dbp.EscapeValue(r.fk))
+	condition := squirrel.And{squirrel.Eq{column: value}}
+	if related.SoftDeleteField() != "" {
+		condition = append(condition, squirrel.Eq{
+			fmt.Sprintf("%s.%s", dbp.EscapeValue(related.Name()), dbp.EscapeValue(related.SoftDeleteField())): nil,
+		})
+	}
+	return condition
+}
+
+// applyDeleteRelations checks the restricted relations of the model first,
+// and then deletes or nullifies the rows of its other relations
+func applyDeleteRelations(dbp DBProvider, table *Table, m Model) error {
+	reflectedM := tools.GetNonPtrValue(m)
+	for _, onDelete := range []OnDelete{OnDeleteRestrict, OnDeleteCascade, OnDeleteSetNull} {
+		for _, r := range table.relations {
+			if r.onDelete != onDelete {
+				continue
+			}
+			related, err := r.relatedTable(dbp, table)
+			if err != nil {
+				return err
+			}
+			value := reflect.Indirect(reflectedM.Field(table.FieldIndex(r.field))).Interface()
+			switch r.onDelete {
+			case OnDeleteRestrict:
+				err = restrictDelete(dbp, table, related, r, value)
+			case OnDeleteCascade:
+				err = cascadeDelete(dbp, related, r, value)
+			case OnDeleteSetNull:
+				err = setNullOnDelete(dbp, related, r, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func restrictDelete(dbp DBProvider, table, related *Table, r relation, value interface{}) error {
+	query, args, err := dbp.getStatementGenerator().Select("COUNT(*) AS count").From(
+		fmt.Sprintf("%s AS %s", related.NameForQuery(dbp), dbp.EscapeValue(related.Name())),
+	).Where(r.where(dbp, related, value)).ToSql()
+	if err != nil {
+		return err
+	}
+	counter := &cnt{}
+	err = dbp.DB().SelectOne(counter, query, args...)
+	if err != nil {
+		return err
+	}
+	if counter.Count > 0 {
+		return &DeleteRestrictedError{Table: table.Name(), RelatedTable: related.Name(), Count: int64(counter.Count)}
+	}
+	return nil
+}
+
+func cascadeDelete(dbp DBProvider, related *Table, r relation, value interface{}) error {
+	m, err := related.NewModel()
+	if err != nil {
+		return err
+	}
+	statement, err := buildSelect(dbp, m, buildSelectColumns{})
+	if err != nil {
+		return err
+	}
+	query, args, err := statement.Where(r.where(dbp, related, value)).ToSql()
+	if err != nil {
+		return err
+	}
+	sm, _ := related.NewSlicePtr()
+	_, err = dbp.DB().Select(sm, query, args...)
+	if err != nil {
+		return err
+	}
+	smValue := tools.GetNonPtrValue(sm)
+	for i := 0; i < smValue.Len(); i++ {
+		child := smValue.Index(i).Interface().(Model)
+		child.SetDBP(dbp)
+		_, err = GenericDelete(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setNullOnDelete sets the fk of the related rows to NULL, the soft deleted rows keeping it
+// GenericRestore cannot set it back once the model has been deleted
+func setNullOnDelete(dbp DBProvider, related *Table, r relation, value interface{}) error {
+	condition := squirrel.Eq{dbp.EscapeValue(r.fk): value}
+	if related.SoftDeleteField() != "" {
+		condition[dbp.EscapeValue(related.SoftDeleteField())] = nil
+	}
+	statement := dbp.getStatementGenerator().Update(related.NameForQuery(dbp)).Set(dbp.EscapeValue(r.fk), nil)
+	// the related rows are modified, the models loaded before must not be saved over them
+	if related.VersionField() != "" {
+		version := dbp.EscapeValue(related.VersionField())
+		statement = statement.Set(version, squirrel.Expr(fmt.Sprintf("%s + 1", version)))
+	}
+	query, args, err := statement.Where(condition).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = dbp.DB().Exec(query, args...)
+	return err
+}
@@ -0,0 +1,230 @@
+package yaorm_test
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericDelete_Restrict(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	post := &testdata.Post{Subject: "subject", CategoryID: category.ID}
+	saveModel(t, dbp, post)
+
+	rows, err := yaorm.GenericDelete(category)
+	assert.Equal(t, int64(0), rows)
+	assert.True(t, stderrors.Is(err, yaorm.ErrDeleteRestricted))
+	assert.EqualError(t, err, "Delete restricted: 1 rows of table post reference table category")
+	err = yaorm.GenericGet(dbp, &testdata.Category{}, category.ID)
+	assert.NoError(t, err)
+
+	_, err = yaorm.GenericDelete(post)
+	assert.NoError(t, err)
+	rows, err = yaorm.GenericDelete(category)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+}
+
+func TestGenericDelete_SetNull(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	other := &testdata.Category{Name: "other"}
+	saveModel(t, dbp, other)
+	task := &testdata.Task{Title: "task", CategoryID: &category.ID}
+	saveModel(t, dbp, task)
+	otherTask := &testdata.Task{Title: "other", CategoryID: &other.ID}
+	saveModel(t, dbp, otherTask)
+	deletedTask := &testdata.Task{Title: "deleted", CategoryID: &category.ID}
+	saveModel(t, dbp, deletedTask)
+	_, err = yaorm.GenericDelete(deletedTask)
+	assert.NoError(t, err)
+
+	rows, err := yaorm.GenericDelete(category)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	found := &testdata.Task{}
+	err = yaorm.GenericGet(dbp, found, task.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, found.CategoryID)
+	err = yaorm.GenericGet(dbp, found, otherTask.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, other.ID, *found.CategoryID)
+
+	// soft deleted rows keep their fk
+	withDeleted := testdata.NewTaskFilter().ID(yaormfilter.Equals(deletedTask.ID))
+	withDeleted.WithDeleted()
+	m, err := yaorm.GenericSelectOne(dbp, withDeleted)
+	assert.NoError(t, err)
+	if assert.NotNil(t, m.(*testdata.Task).CategoryID) {
+		assert.Equal(t, category.ID, *m.(*testdata.Task).CategoryID)
+	}
+}
+
+func TestGenericDelete_Cascade(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	other := &testdata.Article{Title: "other"}
+	saveModel(t, dbp, other)
+	comment := &testdata.Comment{ArticleID: article.ID, Body: "body"}
+	saveModel(t, dbp, comment)
+	otherComment := &testdata.Comment{ArticleID: other.ID, Body: "body"}
+	saveModel(t, dbp, otherComment)
+
+	rows, err := yaorm.GenericDelete(article)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	_, err = yaorm.GenericSelectOne(dbp, testdata.NewCommentFilter().ID(yaormfilter.Equals(comment.ID)))
+	assert.True(t, errors.IsNotFound(err))
+	withDeleted := testdata.NewCommentFilter().ID(yaormfilter.Equals(comment.ID))
+	withDeleted.WithDeleted()
+	found, err := yaorm.GenericSelectOne(dbp, withDeleted)
+	assert.NoError(t, err)
+	assert.NotNil(t, found.(*testdata.Comment).DeletedAt)
+	_, err = yaorm.GenericSelectOne(dbp, testdata.NewCommentFilter().ID(yaormfilter.Equals(otherComment.ID)))
+	assert.NoError(t, err)
+}
+
+func TestGenericDelete_RolledBackWithoutRow(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	_, err = yaorm.GenericDelete(category)
+	assert.NoError(t, err)
+	task := &testdata.Task{Title: "task", CategoryID: &category.ID}
+	saveModel(t, dbp, task)
+
+	rows, err := yaorm.GenericDelete(category)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+	found := &testdata.Task{}
+	err = yaorm.GenericGet(dbp, found, task.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found.CategoryID) {
+		assert.Equal(t, category.ID, *found.CategoryID)
+	}
+}
+
+type relationFolder struct {
+	yaorm.DatabaseModel
+	ID        int64      `db:"id"`
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+type relationFile struct {
+	yaorm.DatabaseModel
+	ID       int64 `db:"id"`
+	FolderID int64 `db:"folder_id"`
+}
+
+type relationOwner struct {
+	yaorm.DatabaseModel
+	ID int64 `db:"id"`
+}
+
+type relationItem struct {
+	yaorm.DatabaseModel
+	ID      int64  `db:"id"`
+	OwnerID *int64 `db:"owner_id"`
+	Version int64  `db:"version" yaorm:"version"`
+}
+
+func init() {
+	yaorm.NewTable("relations", "relation_file", &relationFile{})
+	yaorm.NewTable("relations", "relation_folder", &relationFolder{}).WithSoftDelete("deleted_at").
+		WithRelation("relation_file", "id", "folder_id", yaorm.OnDeleteCascade)
+	yaorm.NewTable("relations", "relation_item", &relationItem{})
+	yaorm.NewTable("relations", "relation_owner", &relationOwner{}).
+		WithRelation("relation_item", "id", "owner_id", yaorm.OnDeleteSetNull)
+}
+
+func setupRelationsDatabase(t *testing.T) (yaorm.DBProvider, func()) {
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:             "relations",
+		DSN:              "/tmp/test_relations.sqlite",
+		System:           yaorm.DatabaseSqlite3,
+		AutoCreateTables: true,
+	})
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "relations")
+	assert.NoError(t, err)
+	return dbp, func() {
+		yaorm.UnregisterDB("relations")
+		os.Remove("/tmp/test_relations.sqlite")
+	}
+}
+
+func TestGenericDelete_CascadeFromSoftDelete(t *testing.T) {
+	dbp, kill := setupRelationsDatabase(t)
+	defer kill()
+	folder := &relationFolder{}
+	saveModel(t, dbp, folder)
+	file := &relationFile{FolderID: folder.ID}
+	saveModel(t, dbp, file)
+
+	rows, err := yaorm.GenericDelete(folder)
+	assert.Equal(t, int64(0), rows)
+	assert.EqualError(t, err, "Table relation_folder uses soft delete, its delete cannot be cascaded to table relation_file which does not")
+	assert.Nil(t, folder.DeletedAt)
+	count, err := dbp.DB().SelectInt("SELECT COUNT(*) FROM relation_file")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestGenericDelete_SetNullVersion(t *testing.T) {
+	dbp, kill := setupRelationsDatabase(t)
+	defer kill()
+	owner := &relationOwner{}
+	saveModel(t, dbp, owner)
+	item := &relationItem{OwnerID: &owner.ID}
+	saveModel(t, dbp, item)
+	assert.Equal(t, int64(1), item.Version)
+
+	_, err := yaorm.GenericDelete(owner)
+	assert.NoError(t, err)
+	version, err := dbp.DB().SelectInt("SELECT version FROM relation_item WHERE owner_id IS NULL AND id = ?", item.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	// the item loaded before cannot be saved over the nullified fk
+	err = yaorm.GenericUpdate(item)
+	assert.True(t, stderrors.Is(err, yaorm.ErrStaleObject))
+}
+
+func TestTable_WithRelation_SetNullNotNullable(t *testing.T) {
+	table, err := yaorm.GetTable("relations", "relation_folder")
+	assert.NoError(t, err)
+	defer func() {
+		err, _ := recover().(error)
+		assert.EqualError(t, err, "Field folder_id of table relation_file cannot hold NULL, it must be a pointer to be set to NULL on delete")
+	}()
+	table.WithRelation("relation_file", "id", "folder_id", yaorm.OnDeleteSetNull)
+}
@@ -3,9 +3,13 @@ package yaorm
 import (
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
 	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
 )
 
 type buildSelectColumns struct {
@@ -62,6 +66,37 @@ func buildCount(dbp DBProvider, table *Table) (squirrel.SelectBuilder, error) {
 	return dbp.getStatementGenerator().Select("COUNT(*) AS count").From(dbp.EscapeValue(table.Name())), nil
 }
 
+// buildExists builds the statement selecting 1 if a row of the table exists
+func buildExists(dbp DBProvider, table *Table) squirrel.SelectBuilder {
+	return dbp.getStatementGenerator().Select("1").From(
+		fmt.Sprintf("%s AS %s", table.NameForQuery(dbp), dbp.EscapeValue(table.Name())),
+	)
+}
+
+// buildPluck builds the statement selecting only one column of the table
+// the rows cannot be ordered by another column when the filter selects distinct values,
+// as the databases cannot order distinct rows by a column which is not selected
+func buildPluck(dbp DBProvider, table *Table, filter yaormfilter.Filter, column string) (squirrel.SelectBuilder, error) {
+	if table.FieldIndex(column) < 0 {
+		return squirrel.SelectBuilder{}, errors.Errorf("Cannot find column %s in table %s", column, table.Name())
+	}
+	for _, option := range filter.GetSelectOptions() {
+		if option != yaormfilter.RequestOptions.SelectDistinct {
+			continue
+		}
+		for _, orderBy := range filter.GetOrderBy() {
+			if orderBy.Count || orderBy.Field != column {
+				return squirrel.SelectBuilder{}, errors.Errorf("Cannot order distinct values of column %s by %s", column, orderBy.Field)
+			}
+		}
+	}
+	return dbp.getStatementGenerator().Select(
+		fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(column)),
+	).From(
+		fmt.Sprintf("%s AS %s", table.NameForQuery(dbp), dbp.EscapeValue(table.Name())),
+	), nil
+}
+
 func getNiceArgumentFormatted(v interface{}) string {
 	if v == nil {
 		return "nil"
@@ -84,9 +119,9 @@ func buildInsert(dbp DBProvider, m Model) (squirrel.InsertBuilder, error) {
 		return squirrel.InsertBuilder{}, err
 	}
 	reflectedM := tools.GetNonPtrValue(m)
-	stmt := dbp.getStatementGenerator().Insert(table.Name()).Columns(table.FieldsWithoutPK()...)
+	stmt := dbp.getStatementGenerator().Insert(table.Name()).Columns(table.writableFields()...)
 	var values []interface{}
-	for _, field := range table.FieldsWithoutPK() {
+	for _, field := range table.writableFields() {
 		values = append(values, getNiceArgumentFormatted(reflectedM.Field(table.FieldIndex(field)).Interface()))
 	}
 	stmt = stmt.Values(values...)
@@ -100,12 +135,20 @@ func buildUpdate(dbp DBProvider, m Model) (squirrel.UpdateBuilder, error) {
 	}
 	reflectedM := tools.GetNonPtrValue(m)
 	stmt := dbp.getStatementGenerator().Update(table.Name())
-	for _, field := range table.FieldsWithoutPK() {
+	version, versioned := getVersion(table, reflectedM)
+	for _, field := range table.writableFields() {
+		if field == table.VersionField() {
+			stmt = stmt.Set(field, version+1)
+			continue
+		}
 		stmt = stmt.Set(field, getNiceArgumentFormatted(reflectedM.Field(table.FieldIndex(field)).Interface()))
 	}
 	for pk, idx := range table.KeyFields() {
 		stmt = stmt.Where(squirrel.Eq{pk: tools.GetNonPtrInterface(reflectedM.Field(idx).Interface())})
 	}
+	if versioned {
+		stmt = stmt.Where(squirrel.Eq{table.VersionField(): version})
+	}
 	return stmt, nil
 }
 
@@ -119,5 +162,119 @@ func buildDelete(dbp DBProvider, m Model) (squirrel.DeleteBuilder, error) {
 	for pk, idx := range table.KeyFields() {
 		stmt = stmt.Where(squirrel.Eq{pk: getNiceArgumentFormatted(reflectedM.Field(idx).Interface())})
 	}
+	if version, versioned := getVersion(table, reflectedM); versioned {
+		stmt = stmt.Where(squirrel.Eq{table.VersionField(): version})
+	}
 	return stmt, nil
 }
+
+// buildInsertReturning builds the statement inserting the model, returning the values generated by the
+// database: auto incremented keys and readonly fields
+func buildInsertReturning(dbp DBProvider, m Model) (squirrel.InsertBuilder, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return squirrel.InsertBuilder{}, err
+	}
+	reflectedM := tools.GetNonPtrValue(m)
+	fields := table.writableFields()
+	returning := table.ReadonlyFields()
+	if table.autoIncrement() {
+		returning = append(append([]string{}, table.Keys()...), returning...)
+	} else {
+		fields = append(append([]string{}, table.Keys()...), fields...)
+	}
+	columns := make([]string, 0, len(fields))
+	values := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, dbp.EscapeValue(field))
+		values = append(values, reflectedM.Field(table.FieldIndex(field)).Interface())
+	}
+	return dbp.getStatementGenerator().Insert(table.NameForQuery(dbp)).Columns(columns...).Values(values...).Suffix(
+		returningClause(dbp, returning),
+	), nil
+}
+
+// buildUpdateReturning builds the statement updating the model, returning the readonly fields
+func buildUpdateReturning(dbp DBProvider, m Model) (squirrel.UpdateBuilder, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return squirrel.UpdateBuilder{}, err
+	}
+	reflectedM := tools.GetNonPtrValue(m)
+	stmt := dbp.getStatementGenerator().Update(table.NameForQuery(dbp))
+	version, versioned := getVersion(table, reflectedM)
+	for _, field := range table.writableFields() {
+		if field == table.VersionField() {
+			stmt = stmt.Set(dbp.EscapeValue(field), version+1)
+			continue
+		}
+		stmt = stmt.Set(dbp.EscapeValue(field), reflectedM.Field(table.FieldIndex(field)).Interface())
+	}
+	for pk, idx := range table.KeyFields() {
+		stmt = stmt.Where(squirrel.Eq{dbp.EscapeValue(pk): tools.GetNonPtrInterface(reflectedM.Field(idx).Interface())})
+	}
+	if versioned {
+		stmt = stmt.Where(squirrel.Eq{dbp.EscapeValue(table.VersionField()): version})
+	}
+	return stmt.Suffix(returningClause(dbp, table.ReadonlyFields())), nil
+}
+
+// buildSelectReadonly builds the statement selecting the readonly fields of the model, by primary key
+func buildSelectReadonly(dbp DBProvider, m Model) (squirrel.SelectBuilder, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+	reflectedM := tools.GetNonPtrValue(m)
+	columns := make([]string, 0, len(table.ReadonlyFields()))
+	for _, field := range table.ReadonlyFields() {
+		columns = append(columns, dbp.EscapeValue(field))
+	}
+	stmt := dbp.getStatementGenerator().Select(columns...).From(table.NameForQuery(dbp))
+	for pk, idx := range table.KeyFields() {
+		stmt = stmt.Where(squirrel.Eq{dbp.EscapeValue(pk): tools.GetNonPtrInterface(reflectedM.Field(idx).Interface())})
+	}
+	return stmt, nil
+}
+
+func returningClause(dbp DBProvider, fields []string) string {
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, dbp.EscapeValue(field))
+	}
+	return fmt.Sprintf("RETURNING %s", strings.Join(columns, ", "))
+}
+
+// buildSoftDelete builds the statement setting the soft delete field of the model
+// a nil deletedAt restores the model, otherwise the model is flagged as deleted
+func buildSoftDelete(dbp DBProvider, m Model, deletedAt *time.Time) (squirrel.UpdateBuilder, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return squirrel.UpdateBuilder{}, err
+	}
+	reflectedM := tools.GetNonPtrValue(m)
+	field := table.SoftDeleteField()
+	stmt := dbp.getStatementGenerator().Update(table.Name())
+	if deletedAt != nil {
+		stmt = stmt.Set(field, *deletedAt).Where(squirrel.Eq{field: nil})
+	} else {
+		stmt = stmt.Set(field, nil).Where(squirrel.NotEq{field: nil})
+	}
+	for pk, idx := range table.KeyFields() {
+		stmt = stmt.Where(squirrel.Eq{pk: tools.GetNonPtrInterface(reflectedM.Field(idx).Interface())})
+	}
+	if version, versioned := getVersion(table, reflectedM); versioned {
+		stmt = stmt.Set(table.VersionField(), version+1).Where(squirrel.Eq{table.VersionField(): version})
+	}
+	return stmt, nil
+}
+
+// getVersion returns the version held by the model, and whether this version has to be checked
+// the version is not checked until the model has been inserted (version 0), as gorp does
+func getVersion(table *Table, reflectedM reflect.Value) (int64, bool) {
+	if table.VersionField() == "" {
+		return 0, false
+	}
+	version := reflectedM.Field(table.FieldIndex(table.VersionField())).Int()
+	return version, version > 0
+}
@@ -0,0 +1,104 @@
+package yaorm
+
+import (
+	"context"
+	stderrors "errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// RetryPolicy describes how a transaction failing with a transient error, like a serialization failure or a deadlock,
+// is run again from scratch by RunInTransactionWithOptions
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the transaction runs at most, the first attempt included
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubled before each following one
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts, 0 not capping it
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay randomly removed, between 0 and 1, so that concurrent transactions
+	// failing together do not run again together
+	Jitter float64
+	// Retryable returns whether the transaction runs again after failing with the error,
+	// IsRetryableError being used when nil
+	Retryable func(err error) bool
+}
+
+// shouldRetry returns whether the transaction runs again after failing with the error at the attempt
+func (p *RetryPolicy) shouldRetry(err error, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryableError(err)
+}
+
+// delay returns the time waited after the attempt failed
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * p.Jitter * rand.Float64())
+	}
+	return d
+}
+
+// wait waits before running the transaction again, unless the context is done first
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) error {
+	d := p.delay(attempt)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsRetryableError returns whether the error is a transient failure of a transaction, which may succeed when run again:
+// a serialization failure (40001) or a deadlock (40P01) on PostgreSQL, a deadlock (1213) on MySQL,
+// or a busy database (SQLITE_BUSY) on SQLite
+// The errors of the drivers are recognized along the chain of wrapped errors, and behind the annotations of juju/errors
+func IsRetryableError(err error) bool {
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var sqliteErr sqlite3.Error
+	for _, e := range []error{err, errors.Cause(err)} {
+		switch {
+		case stderrors.As(e, &pqErr):
+			return pqErr.Code == "40001" || pqErr.Code == "40P01"
+		case stderrors.As(e, &mysqlErr):
+			return mysqlErr.Number == 1213
+		case stderrors.As(e, &sqliteErr):
+			return sqliteErr.Code == sqlite3.ErrBusy
+		}
+	}
+	return false
+}
+
+// transactionAttemptKey is the key of the context value holding the attempt of the running transaction
+type transactionAttemptKey struct{}
+
+// TransactionAttempt returns the attempt of the transaction run by RunInTransaction the queries of the context belong to,
+// 1 for its first run, or 0 outside of such a transaction
+// The context is the one provided to the ExecutorHook, and returned by DBProvider.Context
+func TransactionAttempt(ctx context.Context) int {
+	attempt, _ := ctx.Value(transactionAttemptKey{}).(int)
+	return attempt
+}
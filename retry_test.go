@@ -0,0 +1,189 @@
+package yaorm_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/go-gorp/gorp"
+	"github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, yaorm.IsRetryableError(&pq.Error{Code: "40001"}))
+	assert.True(t, yaorm.IsRetryableError(&pq.Error{Code: "40P01"}))
+	assert.False(t, yaorm.IsRetryableError(&pq.Error{Code: "23505"}))
+	assert.True(t, yaorm.IsRetryableError(&mysql.MySQLError{Number: 1213}))
+	assert.False(t, yaorm.IsRetryableError(&mysql.MySQLError{Number: 1062}))
+	assert.True(t, yaorm.IsRetryableError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	assert.False(t, yaorm.IsRetryableError(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+	assert.True(t, yaorm.IsRetryableError(errors.Annotate(&pq.Error{Code: "40001"}, "cannot save")))
+	assert.True(t, yaorm.IsRetryableError(fmt.Errorf("cannot save: %w", &mysql.MySQLError{Number: 1213})))
+	assert.False(t, yaorm.IsRetryableError(fmt.Errorf("serialization failure")))
+	assert.False(t, yaorm.IsRetryableError(nil))
+}
+
+var transactionAttempts []int
+
+type attemptExecutorHook struct {
+	yaorm.DefaultExecutorHook
+}
+
+func (h *attemptExecutorHook) BeforeInsert(ctx context.Context, query string, args ...interface{}) {
+	transactionAttempts = append(transactionAttempts, yaorm.TransactionAttempt(ctx))
+}
+
+func TestDBProvider_RunInTransactionWithRetry(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	defer func() {
+		os.Remove("/tmp/test_test.sqlite")
+		yaorm.UnregisterDB("test")
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:             "test",
+		DSN:              "/tmp/test_test.sqlite",
+		System:           yaorm.DatabaseSqlite3,
+		AutoCreateTables: true,
+		ExecutorHook:     &attemptExecutorHook{},
+	})
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	transactionAttempts = nil
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	policy := &yaorm.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Jitter: 0.5}
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: policy}, func() error {
+		m := &testdata.Category{Name: "category"}
+		m.SetDBP(dbp)
+		if err := yaorm.GenericSave(m); err != nil {
+			return err
+		}
+		if yaorm.TransactionAttempt(dbp.Context()) < 3 {
+			return busy
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, transactionAttempts)
+	assert.Equal(t, 0, yaorm.TransactionAttempt(dbp.Context()))
+	count, err := yaorm.GenericCount(dbp, testdata.NewCategoryFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	// the last error is returned once the attempts are exhausted
+	attempts := 0
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: policy}, func() error {
+		attempts++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 3, attempts)
+
+	// other errors are not retried
+	attempts = 0
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: policy}, func() error {
+		attempts++
+		return fmt.Errorf("test error")
+	})
+	assert.EqualError(t, err, "test error")
+	assert.Equal(t, 1, attempts)
+
+	// a transaction whose commit fails runs again in a new transaction
+	attempts = 0
+	retryCommit := &yaorm.RetryPolicy{MaxAttempts: 2, Retryable: func(err error) bool { return err == sql.ErrTxDone }}
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: retryCommit}, func() error {
+		attempts++
+		m := &testdata.Category{Name: fmt.Sprintf("attempt %d", attempts)}
+		m.SetDBP(dbp)
+		if err := yaorm.GenericSave(m); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// the transaction is rolled back behind the DBProvider, so that its commit fails
+			return dbp.DB().(*yaorm.SqlExecutor).SqlExecutor.(*gorp.Transaction).Rollback()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	categories, err := yaorm.GenericSelectAll(dbp, testdata.NewCategoryFilter().Name(yaormfilter.Equals("attempt 2")))
+	assert.NoError(t, err)
+	assert.Len(t, categories, 1)
+	count, err = yaorm.GenericCount(dbp, testdata.NewCategoryFilter().Name(yaormfilter.Equals("attempt 1")))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	// a cancelled context stops retrying
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	dbp, err = yaorm.NewDBProvider(ctx, "test")
+	assert.NoError(t, err)
+	attempts = 0
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: &yaorm.RetryPolicy{MaxAttempts: 3, Backoff: time.Second}}, func() error {
+		attempts++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDBProvider_RunInTransaction_DatabaseRetry(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	defer func() {
+		os.Remove("/tmp/test_test.sqlite")
+		yaorm.UnregisterDB("test")
+	}()
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:             "test",
+		DSN:              "/tmp/test_test.sqlite",
+		System:           yaorm.DatabaseSqlite3,
+		AutoCreateTables: true,
+		TransactionRetry: &yaorm.RetryPolicy{MaxAttempts: 3},
+	})
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	attempts := 0
+	err = dbp.RunInTransaction(func() error {
+		attempts++
+		if attempts < 3 {
+			return busy
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// the policy of the options replaces the one of the database
+	attempts = 0
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: &yaorm.RetryPolicy{MaxAttempts: 2}}, func() error {
+		attempts++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 2, attempts)
+	attempts = 0
+	err = dbp.RunInTransactionWithOptions(yaorm.TxOptions{Retry: &yaorm.RetryPolicy{}}, func() error {
+		attempts++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 1, attempts)
+}
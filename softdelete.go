@@ -0,0 +1,58 @@
+package yaorm
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/juju/errors"
+)
+
+// GenericRestore restores the provided model, previously deleted by GenericDelete
+// on a table using soft delete
+// The relations applied by GenericDelete are not undone: the models deleted in cascade stay deleted,
+// and the foreign keys set to NULL are not set back
+// Returns the number of rows restored
+func GenericRestore(m Model) (int64, error) {
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return 0, err
+	}
+	if table.SoftDeleteField() == "" {
+		return 0, errors.Errorf("Table %s does not use soft delete", table.Name())
+	}
+	return setSoftDeleteField(table, m, nil)
+}
+
+// setSoftDeleteField stores deletedAt inside the soft delete field of the model, in the database first
+// and then in the model itself if the row has been modified
+func setSoftDeleteField(table *Table, m Model, deletedAt *time.Time) (int64, error) {
+	dbp := m.GetDBP()
+	stmt, err := buildSoftDelete(dbp, m, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := dbp.DB().Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	reflectedM := tools.GetNonPtrValue(m)
+	if version, versioned := getVersion(table, reflectedM); versioned {
+		if rows == 0 {
+			return 0, newStaleObjectError(dbp, table, m, version)
+		}
+		reflectedM.Field(table.FieldIndex(table.VersionField())).SetInt(version + 1)
+	}
+	if rows > 0 {
+		reflectedM.Field(table.FieldIndex(table.SoftDeleteField())).Set(reflect.ValueOf(deletedAt))
+	}
+	return rows, nil
+}
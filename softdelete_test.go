@@ -0,0 +1,117 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericDelete_SoftDelete(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "deleted"}
+	saveModel(t, dbp, article)
+	article2 := &testdata.Article{Title: "alive"}
+	saveModel(t, dbp, article2)
+
+	rows, err := yaorm.GenericDelete(article)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.NotNil(t, article.DeletedAt)
+	assert.Equal(t, int64(2), article.Version)
+
+	_, err = yaorm.GenericSelectOne(dbp, testdata.NewArticleFilter().ID(yaormfilter.Equals(article.ID)))
+	assert.True(t, errors.IsNotFound(err))
+	count, err := yaorm.GenericCount(dbp, testdata.NewArticleFilter())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	withDeleted := testdata.NewArticleFilter()
+	withDeleted.WithDeleted()
+	models, err := yaorm.GenericSelectAll(dbp, withDeleted)
+	assert.NoError(t, err)
+	assert.Len(t, models, 2)
+
+	onlyDeleted := testdata.NewArticleFilter()
+	onlyDeleted.OnlyDeleted()
+	models, err = yaorm.GenericSelectAll(dbp, onlyDeleted)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		assert.Equal(t, article.ID, models[0].(*testdata.Article).ID)
+		assert.NotNil(t, models[0].(*testdata.Article).DeletedAt)
+	}
+}
+
+func TestGenericRestore(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	comment := &testdata.Comment{Body: "body"}
+	saveModel(t, dbp, comment)
+
+	rows, err := yaorm.GenericDelete(comment)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	rows, err = yaorm.GenericDelete(comment)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+
+	rows, err = yaorm.GenericRestore(comment)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.Nil(t, comment.DeletedAt)
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewCommentFilter().ID(yaormfilter.Equals(comment.ID)))
+	assert.NoError(t, err)
+	assert.Nil(t, found.(*testdata.Comment).DeletedAt)
+
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	_, err = yaorm.GenericRestore(category)
+	assert.Error(t, err)
+}
+
+func TestSoftDelete_JoinAndSubqueryload(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	article := &testdata.Article{Title: "title"}
+	saveModel(t, dbp, article)
+	alive := &testdata.Comment{ArticleID: article.ID, Body: "alive"}
+	saveModel(t, dbp, alive)
+	deleted := &testdata.Comment{ArticleID: article.ID, Body: "deleted"}
+	saveModel(t, dbp, deleted)
+	_, err = yaorm.GenericDelete(deleted)
+	assert.NoError(t, err)
+
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewArticleFilter().Comments(
+		testdata.NewCommentFilter().Body(yaormfilter.Equals("deleted")),
+	))
+	assert.NoError(t, err)
+	assert.Len(t, models, 0)
+
+	withDeleted := testdata.NewCommentFilter().Body(yaormfilter.Equals("deleted"))
+	withDeleted.WithDeleted()
+	models, err = yaorm.GenericSelectAll(dbp, testdata.NewArticleFilter().Comments(withDeleted))
+	assert.NoError(t, err)
+	assert.Len(t, models, 1)
+
+	model, err := yaorm.GenericSelectOne(dbp, testdata.NewArticleFilter().ID(yaormfilter.Equals(article.ID)).Comments(
+		testdata.NewCommentFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, model.(*testdata.Article).Comments, 1) {
+		assert.Equal(t, alive.ID, model.(*testdata.Article).Comments[0].ID)
+	}
+}
@@ -3,10 +3,85 @@ package yaorm
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"reflect"
 
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
 	"github.com/go-gorp/gorp"
+	"github.com/juju/errors"
 )
 
+var (
+	// ErrStaleObject is matched, using errors.Is, by the error returned when an update or a delete
+	// of a versioned model did not affect any row
+	ErrStaleObject = errors.Errorf("Stale object")
+)
+
+// StaleObjectError is returned when the version of a model is not the one stored in the database anymore:
+// the row has either been modified or deleted since the model was loaded
+type StaleObjectError struct {
+	// Table is the name of the table of the model
+	Table string
+	// Keys are the primary key values of the model
+	Keys []interface{}
+	// Version is the version held by the model
+	Version int64
+	// RowExists is false when no row matches the primary keys anymore
+	RowExists bool
+}
+
+func (e *StaleObjectError) Error() string {
+	if e.RowExists {
+		return fmt.Sprintf("Stale object: table %s with keys %v is not at version %d anymore", e.Table, e.Keys, e.Version)
+	}
+	return fmt.Sprintf("Stale object: table %s with keys %v does not exist anymore", e.Table, e.Keys)
+}
+
+// Is allows matching ErrStaleObject with errors.Is
+func (e *StaleObjectError) Is(target error) bool {
+	return target == ErrStaleObject
+}
+
+// newStaleObjectError builds the error returned when a statement on a versioned model did not affect any row
+func newStaleObjectError(dbp DBProvider, table *Table, m Model, version int64) error {
+	reflectedM := tools.GetNonPtrValue(m)
+	stmt := dbp.getStatementGenerator().Select("COUNT(*) AS count").From(table.Name())
+	keys := []interface{}{}
+	for _, pk := range table.Keys() {
+		value := tools.GetNonPtrInterface(reflectedM.Field(table.FieldIndex(pk)).Interface())
+		stmt = stmt.Where(squirrel.Eq{pk: value})
+		keys = append(keys, value)
+	}
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return err
+	}
+	counter := &cnt{}
+	err = dbp.DB().SelectOne(counter, query, args...)
+	if err != nil {
+		return err
+	}
+	return &StaleObjectError{
+		Table:     table.Name(),
+		Keys:      keys,
+		Version:   version,
+		RowExists: counter.Count > 0,
+	}
+}
+
+func convertLockError(err error) error {
+	if lockErr, ok := err.(gorp.OptimisticLockError); ok {
+		return &StaleObjectError{
+			Table:     lockErr.TableName,
+			Keys:      lockErr.Keys,
+			Version:   lockErr.LocalVersion,
+			RowExists: lockErr.RowExists,
+		}
+	}
+	return err
+}
+
 // SqlExecutor is a custom SQL Executor, on top of the one provided by gorp
 // used to provide multiple hooks before executing statements
 type SqlExecutor struct {
@@ -16,6 +91,15 @@ type SqlExecutor struct {
 	dbp DBProvider
 }
 
+// sqlExecutor returns the executor of the DBProvider, which runs the statements through the executor hooks
+func sqlExecutor(dbp DBProvider) (*SqlExecutor, error) {
+	executor, ok := dbp.DB().(*SqlExecutor)
+	if !ok {
+		return nil, errors.Errorf("DBProvider %T does not provide a yaorm SqlExecutor", dbp)
+	}
+	return executor, nil
+}
+
 // SelectOne is a handler to select only 1 row from database and store it inside the first argument
 func (e *SqlExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
 	hook := e.db.ExecutorHook()
@@ -40,9 +124,21 @@ func (e *SqlExecutor) Select(i interface{}, query string, args ...interface{}) (
 }
 
 // Insert is a handler to insert a list of models inside the database
+// readonly fields are refreshed from the database once the model is inserted
 func (e *SqlExecutor) Insert(list ...interface{}) error {
 	hook := e.db.ExecutorHook()
 	for _, item := range list {
+		table, err := GetTableByModel(item.(Model))
+		if err != nil {
+			return err
+		}
+		if len(table.ReadonlyFields()) > 0 && e.dbp.HasCapacity(DatabaseCapacityReturning) {
+			err = e.insertReturning(hook, table, item.(Model))
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		var qArg queryArgs
 		builder, err := buildInsert(e.dbp, item.(Model))
 		if err != nil {
@@ -58,6 +154,75 @@ func (e *SqlExecutor) Insert(list ...interface{}) error {
 			return err
 		}
 		hook.AfterInsert(e.ctx, qArg.query, qArg.args...)
+		if len(table.ReadonlyFields()) > 0 {
+			err = e.selectReadonlyFields(item.(Model))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertReturning inserts the model and reads back its generated values with a RETURNING clause
+func (e *SqlExecutor) insertReturning(hook ExecutorHook, table *Table, m Model) error {
+	if table.VersionField() != "" {
+		// same behaviour as gorp: a model is inserted at version 1
+		version := tools.GetNonPtrValue(m).Field(table.FieldIndex(table.VersionField()))
+		if version.Int() == 0 {
+			version.SetInt(1)
+		}
+	}
+	builder, err := buildInsertReturning(e.dbp, m)
+	if err != nil {
+		return err
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+	returning := table.ReadonlyFields()
+	if table.autoIncrement() {
+		returning = append(append([]string{}, table.Keys()...), returning...)
+	}
+	hook.BeforeInsert(e.ctx, query, args...)
+	err = scanFields(e.SqlExecutor.SelectOne, table, m, returning, query, args...)
+	if err != nil {
+		return err
+	}
+	hook.AfterInsert(e.ctx, query, args...)
+	return nil
+}
+
+// selectReadonlyFields reads back the readonly fields of the model, when RETURNING is not available
+func (e *SqlExecutor) selectReadonlyFields(m Model) error {
+	builder, err := buildSelectReadonly(e.dbp, m)
+	if err != nil {
+		return err
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+	table, err := GetTableByModel(m)
+	if err != nil {
+		return err
+	}
+	return scanFields(e.SelectOne, table, m, table.ReadonlyFields(), query, args...)
+}
+
+// scanFields runs the query and copies the provided fields of the selected row into the model
+// gorp replaces the whole struct when selecting into it, losing the fields which were not selected
+func scanFields(selectOne func(interface{}, string, ...interface{}) error, table *Table, m Model, fields []string, query string, args ...interface{}) error {
+	reflectedM := tools.GetNonPtrValue(m)
+	holder := reflect.New(reflectedM.Type())
+	err := selectOne(holder.Interface(), query, args...)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		idx := table.FieldIndex(field)
+		reflectedM.Field(idx).Set(holder.Elem().Field(idx))
 	}
 	return nil
 }
@@ -67,6 +232,18 @@ func (e *SqlExecutor) Update(list ...interface{}) (int64, error) {
 	hook := e.db.ExecutorHook()
 	var rv int64
 	for _, item := range list {
+		table, err := GetTableByModel(item.(Model))
+		if err != nil {
+			return rv, err
+		}
+		if len(table.ReadonlyFields()) > 0 && e.dbp.HasCapacity(DatabaseCapacityReturning) {
+			v, err := e.updateReturning(hook, table, item.(Model))
+			if err != nil {
+				return rv, err
+			}
+			rv += v
+			continue
+		}
 		var qArg queryArgs
 		builder, err := buildUpdate(e.dbp, item.(Model))
 		if err != nil {
@@ -79,14 +256,49 @@ func (e *SqlExecutor) Update(list ...interface{}) (int64, error) {
 		hook.BeforeUpdate(e.ctx, qArg.query, qArg.args...)
 		v, err := e.SqlExecutor.Update(item)
 		if err != nil {
-			return rv, err
+			return rv, convertLockError(err)
 		}
 		rv += v
 		hook.AfterUpdate(e.ctx, qArg.query, qArg.args...)
+		if v > 0 && len(table.ReadonlyFields()) > 0 {
+			err = e.selectReadonlyFields(item.(Model))
+			if err != nil {
+				return rv, err
+			}
+		}
 	}
 	return rv, nil
 }
 
+// updateReturning updates the model and reads back its readonly fields with a RETURNING clause
+func (e *SqlExecutor) updateReturning(hook ExecutorHook, table *Table, m Model) (int64, error) {
+	builder, err := buildUpdateReturning(e.dbp, m)
+	if err != nil {
+		return 0, err
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	hook.BeforeUpdate(e.ctx, query, args...)
+	reflectedM := tools.GetNonPtrValue(m)
+	version, versioned := getVersion(table, reflectedM)
+	err = scanFields(e.SqlExecutor.SelectOne, table, m, table.ReadonlyFields(), query, args...)
+	if err == sql.ErrNoRows {
+		if versioned {
+			return 0, newStaleObjectError(e.dbp, table, m, version)
+		}
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if table.VersionField() != "" {
+		reflectedM.Field(table.FieldIndex(table.VersionField())).SetInt(version + 1)
+	}
+	hook.AfterUpdate(e.ctx, query, args...)
+	return 1, nil
+}
+
 // Delete is a handler to delete a list of models from the database
 func (e *SqlExecutor) Delete(list ...interface{}) (int64, error) {
 	hook := e.db.ExecutorHook()
@@ -104,7 +316,7 @@ func (e *SqlExecutor) Delete(list ...interface{}) (int64, error) {
 		hook.BeforeDelete(e.ctx, qArg.query, qArg.args...)
 		v, err := e.SqlExecutor.Delete(item)
 		if err != nil {
-			return rv, err
+			return rv, convertLockError(err)
 		}
 		rv += v
 		hook.AfterDelete(e.ctx, qArg.query, qArg.args...)
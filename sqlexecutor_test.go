@@ -2,10 +2,12 @@ package yaorm_test
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"github.com/geoffreybauduin/yaorm"
 	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,3 +20,109 @@ func TestSqlExecutor_Exec(t *testing.T) {
 	_, err = dbp.DB().Exec("SELECT 1")
 	assert.NoError(t, err)
 }
+
+func setupTicketTable(t *testing.T, dbp yaorm.DBProvider) {
+	for _, query := range []string{
+		`DROP TABLE ticket`,
+		`CREATE TABLE ticket (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subject TEXT,
+			reference TEXT NOT NULL DEFAULT 'generated',
+			revision INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TRIGGER ticket_revision AFTER UPDATE OF subject ON ticket BEGIN
+			UPDATE ticket SET revision = revision + 1 WHERE id = NEW.id;
+		END`,
+	} {
+		_, err := dbp.DB().Exec(query)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSqlExecutor_ReadonlyFields(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	setupTicketTable(t, dbp)
+
+	ticket := &testdata.Ticket{Subject: "subject", Reference: "ignored", Revision: 42}
+	saveModel(t, dbp, ticket)
+	assert.NotZero(t, ticket.ID)
+	assert.Equal(t, "generated", ticket.Reference)
+	assert.Equal(t, int64(0), ticket.Revision)
+
+	ticket.Subject = "new subject"
+	ticket.Reference = "ignored again"
+	err = yaorm.GenericUpdate(ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, "generated", ticket.Reference)
+	assert.Equal(t, int64(1), ticket.Revision)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewTicketFilter().ID(yaormfilter.Equals(ticket.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, "new subject", found.(*testdata.Ticket).Subject)
+	assert.Equal(t, "generated", found.(*testdata.Ticket).Reference)
+	assert.Equal(t, int64(1), found.(*testdata.Ticket).Revision)
+}
+
+func TestSqlExecutor_ReadonlyFieldsReturning(t *testing.T) {
+	if os.Getenv("DB") != "postgres" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	for _, query := range []string{
+		`DROP TABLE ticket`,
+		`CREATE TABLE ticket (
+			id SERIAL PRIMARY KEY,
+			subject TEXT,
+			reference TEXT NOT NULL DEFAULT 'generated',
+			revision INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE OR REPLACE FUNCTION ticket_revision() RETURNS TRIGGER AS $$
+		BEGIN
+			NEW.revision = OLD.revision + 1;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE TRIGGER ticket_revision BEFORE UPDATE ON ticket FOR EACH ROW EXECUTE PROCEDURE ticket_revision()`,
+	} {
+		_, err := dbp.DB().Exec(query)
+		assert.NoError(t, err)
+	}
+	// generated values are read back with a RETURNING clause
+	assert.True(t, dbp.HasCapacity(yaorm.DatabaseCapacityReturning))
+
+	ticket := &testdata.Ticket{Subject: "subject", Reference: "ignored", Revision: 42}
+	saveModel(t, dbp, ticket)
+	assert.NotZero(t, ticket.ID)
+	assert.Equal(t, "generated", ticket.Reference)
+	assert.Equal(t, int64(0), ticket.Revision)
+
+	ticket.Subject = "new subject"
+	ticket.Reference = "ignored again"
+	err = yaorm.GenericUpdate(ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, "generated", ticket.Reference)
+	assert.Equal(t, int64(1), ticket.Revision)
+
+	found, err := yaorm.GenericSelectOne(dbp, testdata.NewTicketFilter().ID(yaormfilter.Equals(ticket.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, "new subject", found.(*testdata.Ticket).Subject)
+	assert.Equal(t, "generated", found.(*testdata.Ticket).Reference)
+	assert.Equal(t, int64(1), found.(*testdata.Ticket).Revision)
+
+	missing := &testdata.Ticket{ID: ticket.ID + 1, Subject: "missing"}
+	missing.SetDBP(dbp)
+	err = yaorm.GenericUpdate(missing)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), missing.Revision)
+}
@@ -1,10 +1,15 @@
 package yaorm
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
 )
 
 type SubqueryloadFunc func(dbp DBProvider, ids []interface{}) (interface{}, error)
@@ -14,20 +19,61 @@ type subqueryloader struct {
 	fn SubqueryloadFunc
 	// field on loaded model from which to retrieve the associated fk from original model
 	mapperField string
+	// through loads the models of a relation through a join table, along with the fk of their original model
+	through func(dbp DBProvider, ids []interface{}) ([]Model, []interface{}, error)
 }
 
 var (
 	subqueryloaders = map[string]subqueryloader{}
 )
 
-func subqueryload(dbp DBProvider, m map[string]map[interface{}][]reflect.Value) (map[string][]Model, error) {
+// subqueryload loads the models of the relations for the fks of their original models
+// filters holds, per relation, the nested filter ordering or limiting the models loaded for each original model
+func subqueryload(dbp DBProvider, m map[string]map[interface{}][]reflect.Value, filters map[string]yaormfilter.Filter) (map[string][]Model, error) {
 	models := map[string][]Model{}
-	for model, data := range m {
-		modelData, err := subqueryloadForModel(dbp, model, data)
+	relations := make([]string, 0, len(m))
+	for model := range m {
+		relations = append(relations, model)
+	}
+	sort.Strings(relations)
+	tasks := []*subqueryloadTask{}
+	for _, model := range relations {
+		loader, err := getSubqueryloader(dbp, model)
+		if f := filters[model]; err == nil && f != nil && loader.through == nil && strings.Contains(model, "_per_") {
+			if _, ok := subqueryloaders[model]; ok {
+				// the registered function may select the models differently, it cannot be bypassed
+				return models, fmt.Errorf("Subqueryload model %s is loaded by the function registered with WithSubqueryloading, it cannot be ordered or limited per parent", model)
+			}
+			loader, err = perParentLoader(dbp, model, f)
+		}
 		if err != nil {
 			return models, err
 		}
-		models[model] = modelData
+		ids := []interface{}{}
+		for id := range m[model] {
+			ids = append(ids, id)
+		}
+		for factor := 0; factor*loadStep < len(ids); factor++ {
+			nextStep := (factor + 1) * loadStep
+			if nextStep > len(ids) {
+				nextStep = len(ids)
+			}
+			tasks = append(tasks, &subqueryloadTask{model: model, loader: loader, ids: ids[factor*loadStep : nextStep]})
+		}
+		models[model] = []Model{}
+	}
+	err := runSubqueryloadTasks(dbp, tasks)
+	if err != nil {
+		return models, err
+	}
+	// models are set on their receivers once everything is loaded, in the order of the tasks
+	for _, task := range tasks {
+		for i, loaded := range task.models {
+			for _, v := range m[task.model][task.fks[i]] {
+				setOnReceiver(v, loaded)
+			}
+			models[task.model] = append(models[task.model], loaded)
+		}
 	}
 	return models, nil
 }
@@ -36,52 +82,176 @@ func subqueryload(dbp DBProvider, m map[string]map[interface{}][]reflect.Value)
 // pg max is 65536
 const loadStep = 1000
 
-func subqueryloadForModel(dbp DBProvider, model string, data map[interface{}][]reflect.Value) ([]Model, error) {
-	d := []Model{}
-	if _, ok := subqueryloaders[model]; !ok {
-		return d, fmt.Errorf("Subqueryload model %s not defined yet", model)
-	}
-	ids := []interface{}{}
-	for id := range data {
-		ids = append(ids, id)
-	}
-	for factor := 0; factor*loadStep < len(ids); factor++ {
-		var subset []interface{}
-		currentStep := factor * loadStep
-		nextStep := (factor + 1) * loadStep
-		if nextStep < len(ids) {
-			subset = ids[currentStep:nextStep]
-		} else {
-			subset = ids[currentStep:]
-		}
-		models, err := subqueryloaders[model].fn(dbp, subset)
+// subqueryloadTask loads the models of a relation for a chunk of ids
+type subqueryloadTask struct {
+	model  string
+	loader subqueryloader
+	ids    []interface{}
+	// models loaded, along with the fk of their original model
+	models []Model
+	fks    []interface{}
+	err    error
+}
+
+func (task *subqueryloadTask) run(dbp DBProvider) error {
+	if task.loader.through != nil {
+		models, fks, err := task.loader.through(dbp, task.ids)
 		if err != nil {
-			return d, err
+			return err
 		}
-		modelsSlice := reflect.ValueOf(models)
+		task.models, task.fks = models, fks
+		return nil
+	}
+	models, err := task.loader.fn(dbp, task.ids)
+	if err != nil {
+		return err
+	}
+	modelsSlice := reflect.ValueOf(models)
 
-		switch kind := modelsSlice.Kind(); kind {
-		case reflect.Array, reflect.Slice:
-			// only these kinds are allowed
-		default:
-			return nil, fmt.Errorf("a subquery returned a non-array and non-slice type '%s', which is not handled", kind)
+	switch kind := modelsSlice.Kind(); kind {
+	case reflect.Array, reflect.Slice:
+		// only these kinds are allowed
+	default:
+		return fmt.Errorf("a subquery returned a non-array and non-slice type '%s', which is not handled", kind)
+	}
+
+	for i := 0; i < modelsSlice.Len(); i++ {
+		m := modelsSlice.Index(i).Interface().(Model)
+		table, err := GetTableByModel(m)
+		if err != nil {
+			return err
 		}
+		field := tools.GetNonPtrValue(m).Field(table.FieldIndex(task.loader.mapperField))
+		task.models = append(task.models, m)
+		task.fks = append(task.fks, reflect.Indirect(field).Interface())
+	}
+	return nil
+}
+
+// relationsLoading is the state of a select loading its relation tree, shared by the selects loading the relations
+type relationsLoading struct {
+	// depth is the number of selects loading their relations
+	depth int
+	// afterLoads holds the models loaded, whose DBHookAfterLoad hook is called once the whole tree is loaded
+	afterLoads []interface{}
+	// slots bounds the number of subqueryloads running in parallel for the whole tree,
+	// nil when they run one after another
+	slots chan struct{}
+	// ctx is cancelled once a subqueryload has failed, err holding its error
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error
+	lock   sync.Mutex
+}
+
+func newRelationsLoading(ctx context.Context, concurrency int) *relationsLoading {
+	loading := &relationsLoading{}
+	if concurrency > 1 {
+		// the goroutine of the select runs subqueryloads as well, when every slot is taken
+		loading.slots = make(chan struct{}, concurrency-1)
+		loading.ctx, loading.cancel = context.WithCancel(ctx)
+	}
+	return loading
+}
+
+// fail records the error of the first failed subqueryload, and cancels the others
+func (l *relationsLoading) fail(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.err == nil {
+		l.err = err
+		l.cancel()
+	}
+}
+
+// failure returns the error of the first failed subqueryload
+func (l *relationsLoading) failure() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.err
+}
 
-		for i := 0; i < modelsSlice.Len(); i++ {
-			m := modelsSlice.Index(i)
-			table, err := GetTableByModel(m.Interface().(Model))
+func (l *relationsLoading) close() {
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// runSubqueryloadTasks runs the tasks one after another, or in parallel when the database is configured
+// with a SubqueryloadConcurrency and no transaction is running, as its connection cannot be shared
+// the tasks of the whole relation tree share the slots of the select loading it: a task is run by the calling
+// goroutine when no slot is free, and once one has failed, the others are cancelled and its error is returned
+func runSubqueryloadTasks(dbp DBProvider, tasks []*subqueryloadTask) error {
+	loading := dbp.loadingRelations()
+	if loading == nil || loading.slots == nil || len(tasks) <= 1 || inTransaction(dbp) {
+		for _, task := range tasks {
+			err := task.run(dbp)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			field := tools.GetNonPtrValue(m.Interface()).Field(table.FieldIndex(subqueryloaders[model].mapperField))
-			fk := reflect.Indirect(field).Interface()
-			for _, v := range data[fk] {
-				setOnReceiver(v, m.Interface())
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		if loading.ctx.Err() != nil {
+			break
+		}
+		select {
+		case loading.slots <- struct{}{}:
+			wg.Add(1)
+			go func(task *subqueryloadTask) {
+				defer wg.Done()
+				defer func() { <-loading.slots }()
+				task.err = task.run(dbp)
+				if task.err != nil {
+					loading.fail(task.err)
+				}
+			}(task)
+		default:
+			task.err = task.run(dbp)
+			if task.err != nil {
+				loading.fail(task.err)
 			}
-			d = append(d, m.Interface().(Model))
 		}
 	}
-	return d, nil
+	wg.Wait()
+	return loading.failure()
+}
+
+// getSubqueryloader returns the subqueryloader registered with Table.WithSubqueryloading for the model,
+// or derives one from the filter registered on the table, selecting the models with an In filter on the mapper field
+func getSubqueryloader(dbp DBProvider, model string) (subqueryloader, error) {
+	if loader, ok := subqueryloaders[model]; ok {
+		return loader, nil
+	}
+	if relation, ok := throughRelationFromKey(model); ok {
+		return relation.loader(dbp)
+	}
+	tableName, mapperField := model, "id"
+	if parts := strings.SplitN(model, "_per_", 2); len(parts) == 2 {
+		tableName, mapperField = parts[0], parts[1]
+	}
+	table, err := GetTable(dbp.dbName(), tableName)
+	if err != nil || table.filter == nil {
+		return subqueryloader{}, fmt.Errorf("Subqueryload model %s not defined yet", model)
+	}
+	idx := table.FilterFieldIndex(mapperField)
+	valueFilterType := reflect.TypeOf((*yaormfilter.ValueFilter)(nil)).Elem()
+	if idx < 0 || tools.GetNonPtrValue(table.filter).Type().Field(idx).Type != valueFilterType {
+		return subqueryloader{}, fmt.Errorf("Subqueryload model %s not defined yet, and filter %T has no field for %s", model, table.filter, mapperField)
+	}
+	return subqueryloader{
+		fn: func(dbp DBProvider, ids []interface{}) (interface{}, error) {
+			filter, err := table.NewFilter()
+			if err != nil {
+				return nil, err
+			}
+			tools.GetNonPtrValue(filter).Field(idx).Set(reflect.ValueOf(yaormfilter.In(ids...)))
+			return GenericSelectAll(dbp, filter)
+		},
+		mapperField: mapperField,
+	}, nil
 }
 
 func setOnReceiver(v reflect.Value, value interface{}) {
@@ -0,0 +1,292 @@
+package yaorm_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupConcurrentSubqueryloadDatabase(t *testing.T) func() {
+	return setupConcurrentSubqueryloadDatabaseWith(t, &testdata.LoggingExecutor{}, 4)
+}
+
+func setupConcurrentSubqueryloadDatabaseWith(t *testing.T, hook yaorm.ExecutorHook, concurrency int) func() {
+	tmpFile := fmt.Sprintf("/tmp/yaorm_test_%d.sqlite", rand.Int())
+	err := yaorm.RegisterDB(&yaorm.DatabaseConfiguration{
+		Name:                    "test",
+		DSN:                     tmpFile,
+		System:                  yaorm.DatabaseSqlite3,
+		AutoCreateTables:        true,
+		ExecutorHook:            hook,
+		SubqueryloadConcurrency: concurrency,
+	})
+	assert.NoError(t, err)
+	return func() {
+		yaorm.UnregisterDB("test")
+		os.Remove(tmpFile)
+	}
+}
+
+func TestSubqueryload_Concurrency(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb := setupConcurrentSubqueryloadDatabase(t)
+	defer killDb()
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	tag := &testdata.Tag{Tag: "golang"}
+	saveModel(t, dbp, tag)
+	posts := map[int64]*testdata.Post{}
+	for i := 0; i < 5; i++ {
+		category := &testdata.Category{Name: fmt.Sprintf("category %d", i)}
+		saveModel(t, dbp, category)
+		post := &testdata.Post{Subject: fmt.Sprintf("subject %d", i), CategoryID: category.ID}
+		saveModel(t, dbp, post)
+		saveModel(t, dbp, &testdata.PostMetadata{PostID: post.ID, Key: "key", Value: fmt.Sprintf("value %d", i)})
+		assert.NoError(t, yaorm.GenericAttach(post, "tag", tag))
+		posts[post.ID] = post
+	}
+	newFilter := func() yaormfilter.Filter {
+		return testdata.NewPostFilter().Category(
+			testdata.NewCategoryFilter().Subqueryload(),
+		).Metadata(
+			testdata.NewPostMetadataFilter().Subqueryload(),
+		).Tags(
+			testdata.NewTagFilter().Subqueryload(),
+		)
+	}
+	check := func(models []yaorm.Model) {
+		assert.Len(t, models, 5)
+		for _, m := range models {
+			post := m.(*testdata.Post)
+			if assert.NotNil(t, post.Category) {
+				assert.Equal(t, posts[post.ID].CategoryID, post.Category.ID)
+			}
+			if assert.Len(t, post.Metadata, 1) {
+				assert.Equal(t, post.ID, post.Metadata[0].PostID)
+			}
+			if assert.Len(t, post.Tags, 1) {
+				assert.Equal(t, tag.ID, post.Tags[0].ID)
+			}
+		}
+	}
+	models, err := yaorm.GenericSelectAll(dbp, newFilter())
+	assert.NoError(t, err)
+	check(models)
+
+	// a transaction cannot be shared, relations are loaded one after another
+	err = dbp.RunInTransaction(func() error {
+		models, err := yaorm.GenericSelectAll(dbp, newFilter())
+		check(models)
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+var (
+	selectsInFlight    int32
+	maxSelectsInFlight int32
+)
+
+// inFlightExecutor records the maximum number of selects running at the same time
+type inFlightExecutor struct {
+	yaorm.DefaultExecutorHook
+}
+
+func (inFlightExecutor) BeforeSelect(ctx context.Context, query string, args ...interface{}) {
+	current := atomic.AddInt32(&selectsInFlight, 1)
+	for {
+		max := atomic.LoadInt32(&maxSelectsInFlight)
+		if current <= max || atomic.CompareAndSwapInt32(&maxSelectsInFlight, max, current) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (inFlightExecutor) AfterSelect(ctx context.Context, query string, args ...interface{}) {
+	atomic.AddInt32(&selectsInFlight, -1)
+}
+
+func TestSubqueryload_ConcurrencySharedByTree(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb := setupConcurrentSubqueryloadDatabaseWith(t, &inFlightExecutor{}, 2)
+	defer killDb()
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	tag := &testdata.Tag{Tag: "golang"}
+	saveModel(t, dbp, tag)
+	for i := 0; i < 3; i++ {
+		category := &testdata.Category{Name: fmt.Sprintf("category %d", i)}
+		saveModel(t, dbp, category)
+		parent := &testdata.Post{Subject: fmt.Sprintf("parent %d", i), CategoryID: category.ID}
+		saveModel(t, dbp, parent)
+		assert.NoError(t, yaorm.GenericAttach(parent, "tag", tag))
+		child := &testdata.Post{Subject: fmt.Sprintf("child %d", i), CategoryID: category.ID, ParentPostID: parent.ID}
+		saveModel(t, dbp, child)
+		saveModel(t, dbp, &testdata.PostMetadata{PostID: child.ID, Key: "key", Value: "value"})
+		assert.NoError(t, yaorm.GenericAttach(child, "tag", tag))
+	}
+	atomic.StoreInt32(&maxSelectsInFlight, 0)
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().ParentPostID(yaormfilter.Equals(int64(0))).Category(
+		testdata.NewCategoryFilter().Subqueryload(),
+	).Tags(
+		testdata.NewTagFilter().Subqueryload(),
+	).ChildrenPosts(
+		testdata.NewPostFilter().Category(
+			testdata.NewCategoryFilter().Subqueryload(),
+		).Metadata(
+			testdata.NewPostMetadataFilter().Subqueryload(),
+		).Tags(
+			testdata.NewTagFilter().Subqueryload(),
+		).Subqueryload(),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 3) {
+		for _, m := range models {
+			post := m.(*testdata.Post)
+			assert.NotNil(t, post.Category)
+			assert.Len(t, post.Tags, 1)
+			if assert.Len(t, post.ChildrenPost, 1) {
+				assert.NotNil(t, post.ChildrenPost[0].Category)
+				assert.Len(t, post.ChildrenPost[0].Metadata, 1)
+				assert.Len(t, post.ChildrenPost[0].Tags, 1)
+			}
+		}
+	}
+	assert.True(t, atomic.LoadInt32(&maxSelectsInFlight) <= 2)
+}
+
+func TestSubqueryload_ConcurrencyError(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb := setupConcurrentSubqueryloadDatabase(t)
+	defer killDb()
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	is := &testdata.InvalidSubquery{}
+	saveModel(t, dbp, is)
+	saveModel(t, dbp, &testdata.InvalidSubqueryB{InvalidSubqueryID: is.ID})
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewInvalidSubqueryBFilter().InvalidSubquery(
+		testdata.NewInvalidSubqueryFilter().Subqueryload(),
+	))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a subquery returned a non-array and non-slice type 'ptr', which is not handled")
+}
+
+func TestSubqueryload_PerParentLimit(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	authors := []*testdata.Author{}
+	books := map[int64][]int64{}
+	for i := 0; i < 2; i++ {
+		author := &testdata.Author{Name: fmt.Sprintf("author %d", i)}
+		saveModel(t, dbp, author)
+		authors = append(authors, author)
+		for j := 0; j < 3; j++ {
+			book := &testdata.Book{AuthorID: author.ID, Title: fmt.Sprintf("book %d %d", i, j)}
+			saveModel(t, dbp, book)
+			books[author.ID] = append(books[author.ID], book.ID)
+		}
+	}
+	newBookFilter := func(way yaormfilter.OrderingWay, limit, offset uint64) yaormfilter.Filter {
+		f := testdata.NewBookFilter()
+		f.SetOrderBy("id", way)
+		if limit > 0 {
+			f.SetLimit(limit)
+		}
+		if offset > 0 {
+			f.SetOffset(offset)
+		}
+		return f.Subqueryload()
+	}
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewAuthorFilter().ID(
+		yaormfilter.In(authors[0].ID, authors[1].ID),
+	).Books(newBookFilter(yaormfilter.OrderingWays.Desc, 2, 0)))
+	assert.NoError(t, err)
+	assert.Len(t, models, 2)
+	for _, m := range models {
+		author := m.(*testdata.Author)
+		ids := books[author.ID]
+		if assert.Len(t, author.Books, 2) {
+			assert.Equal(t, ids[2], author.Books[0].ID)
+			assert.Equal(t, ids[1], author.Books[1].ID)
+		}
+	}
+
+	models, err = yaorm.GenericSelectAll(dbp, testdata.NewAuthorFilter().ID(yaormfilter.Equals(authors[0].ID)).Books(
+		newBookFilter(yaormfilter.OrderingWays.Asc, 1, 1),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) && assert.Len(t, models[0].(*testdata.Author).Books, 1) {
+		assert.Equal(t, books[authors[0].ID][1], models[0].(*testdata.Author).Books[0].ID)
+	}
+
+	// children posts are loaded by the function registered on the post table, which cannot be limited
+	parent := &testdata.Post{Subject: "parent"}
+	saveModel(t, dbp, parent)
+	saveModel(t, dbp, &testdata.Post{Subject: "child", ParentPostID: parent.ID})
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(parent.ID)).ChildrenPosts(
+		testdata.NewPostFilter().OrderBy("id", yaormfilter.OrderingWays.Desc).Limit(2).Subqueryload(),
+	))
+	assert.Error(t, err)
+}
+
+func TestSubqueryload_PerParentLimitWithoutWindowFunctions(t *testing.T) {
+	if os.Getenv("DB") != "" && os.Getenv("DB") != "sqlite" {
+		return
+	}
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	// window functions were introduced by SQLite 3.25
+	yaorm.SetServerVersion(dbp, 3, 24)
+	authors := []interface{}{}
+	for i := 0; i < 101; i++ {
+		author := &testdata.Author{Name: fmt.Sprintf("author %d", i)}
+		saveModel(t, dbp, author)
+		authors = append(authors, author.ID)
+		for j := 0; j < 2; j++ {
+			saveModel(t, dbp, &testdata.Book{AuthorID: author.ID, Title: fmt.Sprintf("book %d %d", i, j)})
+		}
+	}
+	newBookFilter := func() yaormfilter.Filter {
+		f := testdata.NewBookFilter()
+		f.SetOrderBy("id", yaormfilter.OrderingWays.Desc)
+		f.SetLimit(1)
+		return f.Subqueryload()
+	}
+
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewAuthorFilter().ID(yaormfilter.In(authors[:2]...)).Books(newBookFilter()))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 2) {
+		for _, m := range models {
+			if assert.Len(t, m.(*testdata.Author).Books, 1) {
+				assert.Equal(t, fmt.Sprintf("%s 1", strings.Replace(m.(*testdata.Author).Name, "author", "book", 1)), m.(*testdata.Author).Books[0].Title)
+			}
+		}
+	}
+
+	// one query per parent is only run for a bounded number of parents
+	_, err = yaorm.GenericSelectAll(dbp, testdata.NewAuthorFilter().Books(newBookFilter()))
+	assert.EqualError(t, err, "Database does not provide window functions, models of table book cannot be limited for more than 100 parents at once")
+}
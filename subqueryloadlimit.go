@@ -0,0 +1,145 @@
+package yaorm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+// rowNumberColumn is the name under which the rank of a model among the ones of its parent is selected
+const rowNumberColumn = "yaorm_row_number"
+
+// perParentQueriesLimit caps the number of parents whose models are limited with one query each,
+// when the database does not provide window functions
+const perParentQueriesLimit = 100
+
+// hasPerParentOptions returns true if the filter orders or limits the models loaded for each parent
+func hasPerParentOptions(f yaormfilter.Filter) bool {
+	shouldLimit, _ := f.GetLimit()
+	return shouldLimit || len(f.GetOrderBy()) > 0
+}
+
+// perParentFilter returns the nested filter ordering or limiting the subqueryloaded models, if any
+func perParentFilter(f interface{}) yaormfilter.Filter {
+	switch v := f.(type) {
+	case yaormfilter.Filter:
+		if hasPerParentOptions(v) {
+			return v
+		}
+	case []yaormfilter.Filter:
+		for _, elem := range v {
+			if elem.ShouldSubqueryload() && hasPerParentOptions(elem) {
+				return elem
+			}
+		}
+	}
+	return nil
+}
+
+// perParentLoader returns the subqueryloader selecting the models of the table per mapper field,
+// ordered and limited for each value of the mapper field as requested by the filter
+// the limit relies on ROW_NUMBER() when the database provides window functions, and falls back
+// to one query per parent otherwise, for at most perParentQueriesLimit parents
+func perParentLoader(dbp DBProvider, model string, f yaormfilter.Filter) (subqueryloader, error) {
+	parts := strings.SplitN(model, "_per_", 2)
+	if len(parts) != 2 {
+		return subqueryloader{}, fmt.Errorf("Subqueryload model %s is not loaded per parent, it cannot be ordered or limited", model)
+	}
+	table, err := GetTable(dbp.dbName(), parts[0])
+	if err != nil {
+		return subqueryloader{}, err
+	}
+	mapperField := parts[1]
+	if table.FieldIndex(mapperField) < 0 {
+		return subqueryloader{}, fmt.Errorf("Cannot find field %s in table %s", mapperField, table.Name())
+	}
+	return subqueryloader{
+		fn: func(dbp DBProvider, ids []interface{}) (interface{}, error) {
+			return selectPerParent(dbp, table, mapperField, f, ids)
+		},
+		mapperField: mapperField,
+	}, nil
+}
+
+func selectPerParent(dbp DBProvider, table *Table, mapperField string, f yaormfilter.Filter, ids []interface{}) ([]Model, error) {
+	m, err := table.NewModel()
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := table.NewFilter()
+	if err != nil {
+		return nil, err
+	}
+	statement, err := buildSelect(dbp, m, buildSelectColumns{})
+	if err != nil {
+		return nil, err
+	}
+	statement = apply(statement, fresh, dbp)
+	column := fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(mapperField))
+	orderBy := []string{}
+	for _, o := range f.GetOrderBy() {
+		orderBy = append(orderBy, fmt.Sprintf("%s.%s %s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(o.Field), o.Way))
+	}
+	shouldLimit, limit := f.GetLimit()
+	if !shouldLimit {
+		return selectModels(dbp, table, statement.Where(squirrel.Eq{column: ids}).OrderBy(append([]string{column}, orderBy...)...))
+	}
+	_, offset := f.GetOffset()
+	if !dbp.HasCapacity(DatabaseCapacityWindowFunctions) {
+		if len(ids) > perParentQueriesLimit {
+			return nil, fmt.Errorf(
+				"Database does not provide window functions, models of table %s cannot be limited for more than %d parents at once",
+				table.Name(), perParentQueriesLimit,
+			)
+		}
+		models := []Model{}
+		for _, id := range ids {
+			loaded, err := selectModels(dbp, table, statement.Where(squirrel.Eq{column: id}).OrderBy(orderBy...).Limit(limit).Offset(offset))
+			if err != nil {
+				return nil, err
+			}
+			models = append(models, loaded...)
+		}
+		return models, nil
+	}
+	if len(orderBy) == 0 {
+		for _, key := range table.Keys() {
+			orderBy = append(orderBy, fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(key)))
+		}
+	}
+	ranked := statement.Where(squirrel.Eq{column: ids}).Column(fmt.Sprintf(
+		"ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS %s", column, strings.Join(orderBy, ", "), dbp.EscapeValue(rowNumberColumn),
+	)).PlaceholderFormat(squirrel.Question)
+	fields := make([]string, 0, len(table.Fields()))
+	for _, field := range table.Fields() {
+		fields = append(fields, fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(field)))
+	}
+	rowNumber := fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(rowNumberColumn))
+	return selectModels(dbp, table, dbp.getStatementGenerator().Select(fields...).FromSelect(
+		ranked, dbp.EscapeValue(table.Name()),
+	).Where(fmt.Sprintf("%s > ? AND %s <= ?", rowNumber, rowNumber), offset, offset+limit).OrderBy(column, rowNumber))
+}
+
+// selectModels selects the models of the table returned by the statement, and calls their after load hooks
+func selectModels(dbp DBProvider, table *Table, statement squirrel.SelectBuilder) ([]Model, error) {
+	query, args, err := statement.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	sm, _ := table.NewSlicePtr()
+	_, err = dbp.DB().Select(sm, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	models := []Model{}
+	smValue := tools.GetNonPtrValue(sm)
+	for i := 0; i < smValue.Len(); i++ {
+		m := smValue.Index(i).Interface().(Model)
+		m.SetDBP(dbp)
+		models = append(models, m)
+	}
+	return models, dbp.afterLoad(models)
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm/_vendor/github.com/loopfz/gadgeto/zesty/utils/rekordo"
 	"github.com/geoffreybauduin/yaorm/tools"
@@ -43,6 +44,12 @@ type Table struct {
 	fieldsByDbKey       map[string]int
 	filterFieldsByDbKey map[string]int
 	schema              string
+	versionField        string
+	softDeleteField     string
+	autoCreateTime      []string
+	autoUpdateTime      []string
+	readonlyFields      []string
+	relations           []relation
 }
 
 // NewTable registers a new table
@@ -63,15 +70,26 @@ func NewTable(dbName, tableName string, model Model) *Table {
 	}
 	table.tm = rekordo.RegisterTableModel(dbName, tableName, tools.GetNonPtrInterface(model))
 	tableMutex.Lock()
-	defer tableMutex.Unlock()
 	if _, ok := tables[dbName]; !ok {
 		tables[dbName] = map[string]*Table{}
 	}
 	tables[dbName][tableName] = table
 	tableByType[reflect.TypeOf(model).Elem()] = table
+	tableMutex.Unlock()
+	table.mapTable()
 	return table
 }
 
+// mapTable forwards the table to gorp when its database is already registered,
+// RegisterDB forwarding the tables declared before it
+func (t *Table) mapTable() {
+	dblock.RLock()
+	defer dblock.RUnlock()
+	if d, ok := registry[t.dbname].(*db); ok {
+		configureTableMap(d.dbmap, t)
+	}
+}
+
 // GetTable returns the table matching the parameters
 func GetTable(dbName, tableName string) (*Table, error) {
 	if _, ok := tables[dbName]; !ok {
@@ -116,6 +134,9 @@ func (t *Table) retrieveFields() error {
 		if tagData[0] == "id" {
 			t.keys = []string{"id"}
 		}
+		if err := t.retrieveFieldOptions(field, tagData[0]); err != nil {
+			return err
+		}
 	}
 	t.fields = fields
 	if len(fields) == 0 {
@@ -124,6 +145,41 @@ func (t *Table) retrieveFields() error {
 	return nil
 }
 
+// retrieveFieldOptions reads the yaorm specific options of a field, declared inside the `yaorm` tag
+// (gorp refuses any unknown option inside the `db` tag)
+func (t *Table) retrieveFieldOptions(field reflect.StructField, dbField string) error {
+	options, ok := field.Tag.Lookup("yaorm")
+	if !ok || options == "-" {
+		return nil
+	}
+	for _, option := range strings.Split(options, ",") {
+		switch strings.TrimSpace(option) {
+		case "version":
+			switch field.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return errors.Errorf("Version field %s of table %s must be an integer", dbField, t.name)
+			}
+			t.versionField = dbField
+		case "autocreatetime", "autoupdatetime":
+			if field.Type != reflect.TypeOf(time.Time{}) && field.Type != reflect.TypeOf(&time.Time{}) {
+				return errors.Errorf("Timestamp field %s of table %s must be a time.Time or a *time.Time", dbField, t.name)
+			}
+			if strings.TrimSpace(option) == "autocreatetime" {
+				t.autoCreateTime = append(t.autoCreateTime, dbField)
+			} else {
+				t.autoUpdateTime = append(t.autoUpdateTime, dbField)
+			}
+		case "readonly":
+			t.readonlyFields = append(t.readonlyFields, dbField)
+		case "":
+		default:
+			return errors.Errorf("Unknown yaorm option '%s' on field %s of table %s", option, dbField, t.name)
+		}
+	}
+	return nil
+}
+
 func (t *Table) retrieveFilterFields() {
 	t.filterFieldsByDbKey = map[string]int{}
 	st := reflect.TypeOf(reflect.ValueOf(t.filter).Elem().Interface())
@@ -141,12 +197,14 @@ func (t *Table) retrieveFilterFields() {
 func (t *Table) WithKeys(keys []string) *Table {
 	t.keys = keys
 	t.tm = t.tm.WithKeys(keys)
+	t.mapTable()
 	return t
 }
 
 func (t *Table) WithSchema(schema string) *Table {
 	t.schema = schema
 	t.tm = t.tm.WithSchema(schema)
+	t.mapTable()
 	return t
 }
 
@@ -160,8 +218,48 @@ func (t *Table) WithFilter(f yaormfilter.Filter) *Table {
 	return t
 }
 
+// WithSoftDelete marks the rows of this table as deleted by setting a timestamp on the provided field,
+// instead of removing them. The field must be a *time.Time so that alive rows hold NULL.
+// Selects automatically skip the soft deleted rows, unless the filter uses WithDeleted or OnlyDeleted
+func (t *Table) WithSoftDelete(field string) *Table {
+	idx := t.FieldIndex(field)
+	if idx < 0 {
+		panic(errors.Errorf("Cannot find field %s in table %s", field, t.Name()))
+	}
+	if t.reflectedType.Field(idx).Type != reflect.TypeOf(&time.Time{}) {
+		panic(errors.Errorf("Soft delete field %s of table %s must be a *time.Time", field, t.Name()))
+	}
+	t.softDeleteField = field
+	return t
+}
+
+// WithRelation declares that the rows of the provided table reference the models of this table, their fk column
+// holding the value of field (like a `filterload:"table,field,fk"` tag), and the action applied on these rows by
+// GenericDelete when a model is deleted. Use it when the database does not enforce foreign keys
+// panics if the related table is already registered and its fk cannot hold NULL with OnDeleteSetNull
+func (t *Table) WithRelation(table, field, fk string, onDelete OnDelete) *Table {
+	if t.FieldIndex(field) < 0 {
+		panic(errors.Errorf("Cannot find field %s in table %s", field, t.Name()))
+	}
+	switch onDelete {
+	case OnDeleteCascade, OnDeleteSetNull, OnDeleteRestrict:
+	default:
+		panic(errors.Errorf("Unknown on delete action '%s' for relation %s of table %s", onDelete, table, t.Name()))
+	}
+	r := relation{table: table, field: field, fk: fk, onDelete: onDelete}
+	// the related table may only be registered later, GenericDelete checks it again
+	if related, err := GetTable(t.dbname, table); err == nil {
+		if err := r.checkForeignKey(related); err != nil {
+			panic(err)
+		}
+	}
+	t.relations = append(t.relations, r)
+	return t
+}
+
 func (t *Table) WithAutoIncrement(v bool) *Table {
 	t.tm = t.tm.WithAutoIncrement(v)
+	t.mapTable()
 	return t
 }
 
@@ -197,6 +295,18 @@ func (t Table) KeyFields() map[string]int {
 	return m
 }
 
+// SoftDeleteField returns the db field holding the deletion timestamp, or an empty string
+// if the rows of the table are really deleted
+func (t Table) SoftDeleteField() string {
+	return t.softDeleteField
+}
+
+// VersionField returns the db field used for optimistic locking, or an empty string
+// if the table is not versioned
+func (t Table) VersionField() string {
+	return t.versionField
+}
+
 func (t Table) FieldIndex(field string) int {
 	idx, ok := t.fieldsByDbKey[field]
 	if !ok {
@@ -217,6 +327,31 @@ func (t Table) FieldsWithoutPK() []string {
 	return fields
 }
 
+// ReadonlyFields returns the fields never written by yaorm, as their values are generated by the database
+func (t Table) ReadonlyFields() []string {
+	return t.readonlyFields
+}
+
+// writableFields returns the fields, except primary keys and readonly fields, that are written by yaorm
+func (t Table) writableFields() []string {
+	readonly := map[string]bool{}
+	for _, f := range t.readonlyFields {
+		readonly[f] = true
+	}
+	fields := make([]string, 0)
+	for _, f := range t.FieldsWithoutPK() {
+		if readonly[f] {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func (t Table) autoIncrement() bool {
+	return t.tm.AutoIncrement
+}
+
 func (t Table) FilterFieldIndex(field string) int {
 	idx, ok := t.filterFieldsByDbKey[field]
 	if !ok {
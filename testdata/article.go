@@ -0,0 +1,70 @@
+package testdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+type Article struct {
+	yaorm.DatabaseModel
+	ID        int64      `db:"id"`
+	Title     string     `db:"title"`
+	Version   int64      `db:"version" yaorm:"version"`
+	CreatedAt time.Time  `db:"created_at" yaorm:"autocreatetime"`
+	UpdatedAt time.Time  `db:"updated_at" yaorm:"autoupdatetime"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	Comments  []*Comment `db:"-" filterload:"comment,id,article_id"`
+}
+
+type ArticleFilter struct {
+	yaormfilter.ModelFilter
+	FilterID       yaormfilter.ValueFilter `filter:"id"`
+	FilterTitle    yaormfilter.ValueFilter `filter:"title"`
+	FilterComments []yaormfilter.Filter    `filter:"comment,join,article_id,id" filterload:"comment"`
+}
+
+func init() {
+	yaorm.NewTable("test", "article", &Article{}).WithFilter(&ArticleFilter{}).WithSoftDelete("deleted_at").
+		WithRelation("comment", "id", "article_id", yaorm.OnDeleteCascade)
+}
+
+func (a *Article) Load(dbp yaorm.DBProvider) error {
+	return yaorm.GenericSelectOneFromModel(dbp, a)
+}
+
+func (a *Article) Save() error {
+	return yaorm.GenericSave(a)
+}
+
+func NewArticleFilter() *ArticleFilter {
+	return &ArticleFilter{}
+}
+
+func (f *ArticleFilter) ID(v yaormfilter.ValueFilter) *ArticleFilter {
+	f.FilterID = v
+	return f
+}
+
+func (f *ArticleFilter) Title(v yaormfilter.ValueFilter) *ArticleFilter {
+	f.FilterTitle = v
+	return f
+}
+
+func (f *ArticleFilter) Comments(comments ...yaormfilter.Filter) *ArticleFilter {
+	for _, c := range comments {
+		if _, ok := c.(*CommentFilter); !ok {
+			panic(fmt.Errorf("filter %v is not a CommentFilter", c))
+		}
+		f.FilterComments = append(f.FilterComments, c)
+	}
+	return f
+}
+
+// AddOption adds an option on the current query
+func (f *ArticleFilter) AddOption(opt yaormfilter.RequestOption) yaormfilter.Filter {
+	f.AddOption_(opt)
+	return f
+}
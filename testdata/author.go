@@ -0,0 +1,53 @@
+package testdata
+
+import (
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+// Author and Book do not register any subqueryloader, theirs are derived from the filterload tags
+type Author struct {
+	yaorm.DatabaseModel
+	ID    int64   `db:"id"`
+	Name  string  `db:"name"`
+	Books []*Book `db:"-" filterload:"book,id,author_id"`
+}
+
+type AuthorFilter struct {
+	yaormfilter.ModelFilter
+	FilterID    yaormfilter.ValueFilter `filter:"id"`
+	FilterName  yaormfilter.ValueFilter `filter:"name"`
+	FilterBooks []yaormfilter.Filter    `filter:"book,join,author_id,id" filterload:"book"`
+}
+
+func init() {
+	yaorm.NewTable("test", "author", &Author{}).WithFilter(&AuthorFilter{})
+}
+
+func (a *Author) Save() error {
+	return yaorm.GenericSave(a)
+}
+
+func NewAuthorFilter() *AuthorFilter {
+	return &AuthorFilter{}
+}
+
+func (f *AuthorFilter) ID(v yaormfilter.ValueFilter) *AuthorFilter {
+	f.FilterID = v
+	return f
+}
+
+func (f *AuthorFilter) Name(v yaormfilter.ValueFilter) *AuthorFilter {
+	f.FilterName = v
+	return f
+}
+
+func (f *AuthorFilter) Books(books ...yaormfilter.Filter) *AuthorFilter {
+	f.FilterBooks = append(f.FilterBooks, books...)
+	return f
+}
+
+func (f *AuthorFilter) Subqueryload() yaormfilter.Filter {
+	f.AllowSubqueryload()
+	return f
+}
@@ -0,0 +1,59 @@
+package testdata
+
+import (
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+type Book struct {
+	yaorm.DatabaseModel
+	ID       int64   `db:"id"`
+	AuthorID int64   `db:"author_id"`
+	Title    string  `db:"title"`
+	Author   *Author `db:"-" filterload:"author,author_id"`
+}
+
+type BookFilter struct {
+	yaormfilter.ModelFilter
+	FilterID       yaormfilter.ValueFilter `filter:"id"`
+	FilterAuthorID yaormfilter.ValueFilter `filter:"author_id"`
+	FilterTitle    yaormfilter.ValueFilter `filter:"title"`
+	FilterAuthor   yaormfilter.Filter      `filter:"author,join,id,author_id" filterload:"author"`
+}
+
+func init() {
+	yaorm.NewTable("test", "book", &Book{}).WithFilter(&BookFilter{})
+}
+
+func (b *Book) Save() error {
+	return yaorm.GenericSave(b)
+}
+
+func NewBookFilter() *BookFilter {
+	return &BookFilter{}
+}
+
+func (f *BookFilter) ID(v yaormfilter.ValueFilter) *BookFilter {
+	f.FilterID = v
+	return f
+}
+
+func (f *BookFilter) AuthorID(v yaormfilter.ValueFilter) *BookFilter {
+	f.FilterAuthorID = v
+	return f
+}
+
+func (f *BookFilter) Title(v yaormfilter.ValueFilter) *BookFilter {
+	f.FilterTitle = v
+	return f
+}
+
+func (f *BookFilter) Author(v yaormfilter.Filter) *BookFilter {
+	f.FilterAuthor = v
+	return f
+}
+
+func (f *BookFilter) Subqueryload() yaormfilter.Filter {
+	f.AllowSubqueryload()
+	return f
+}
@@ -13,6 +13,7 @@ type Category struct {
 	Name      string    `db:"name"`
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
+	PostCount int64     `db:"-" count:"post,category_id"`
 }
 
 type CategoryFilter struct {
@@ -27,7 +28,9 @@ func init() {
 			return yaorm.GenericSelectAll(dbp, NewCategoryFilter().ID(yaormfilter.In(ids...)))
 		},
 		"id",
-	)
+	).
+		WithRelation("post", "id", "category_id", yaorm.OnDeleteRestrict).
+		WithRelation("task", "id", "category_id", yaorm.OnDeleteSetNull)
 }
 
 func (c *Category) DBHookBeforeInsert() error {
@@ -0,0 +1,87 @@
+package testdata
+
+import (
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+type Comment struct {
+	yaorm.DatabaseModel
+	ID        int64      `db:"id"`
+	ArticleID int64      `db:"article_id"`
+	Body      string     `db:"body"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	// Events records the after hooks called on this comment
+	Events []string `db:"-"`
+}
+
+type CommentFilter struct {
+	yaormfilter.ModelFilter
+	FilterID        yaormfilter.ValueFilter `filter:"id"`
+	FilterArticleID yaormfilter.ValueFilter `filter:"article_id"`
+	FilterBody      yaormfilter.ValueFilter `filter:"body"`
+}
+
+func init() {
+	yaorm.NewTable("test", "comment", &Comment{}).WithFilter(&CommentFilter{}).WithSoftDelete("deleted_at").WithSubqueryloading(
+		func(dbp yaorm.DBProvider, ids []interface{}) (interface{}, error) {
+			return yaorm.GenericSelectAll(dbp, NewCommentFilter().ArticleID(yaormfilter.In(ids...)))
+		}, "article_id",
+	)
+}
+
+func (c *Comment) Save() error {
+	return yaorm.GenericSave(c)
+}
+
+func (c *Comment) DBHookAfterInsert() error {
+	c.Events = append(c.Events, "insert")
+	return nil
+}
+
+func (c *Comment) DBHookAfterUpdate() error {
+	c.Events = append(c.Events, "update")
+	return nil
+}
+
+func (c *Comment) DBHookAfterDelete() error {
+	c.Events = append(c.Events, "delete")
+	return nil
+}
+
+func (c *Comment) DBHookAfterLoad() error {
+	c.Events = append(c.Events, "load")
+	return nil
+}
+
+func NewCommentFilter() *CommentFilter {
+	return &CommentFilter{}
+}
+
+func (f *CommentFilter) ID(v yaormfilter.ValueFilter) *CommentFilter {
+	f.FilterID = v
+	return f
+}
+
+func (f *CommentFilter) ArticleID(v yaormfilter.ValueFilter) *CommentFilter {
+	f.FilterArticleID = v
+	return f
+}
+
+func (f *CommentFilter) Body(v yaormfilter.ValueFilter) *CommentFilter {
+	f.FilterBody = v
+	return f
+}
+
+func (f *CommentFilter) Subqueryload() yaormfilter.Filter {
+	f.AllowSubqueryload()
+	return f
+}
+
+// AddOption adds an option on the current query
+func (f *CommentFilter) AddOption(opt yaormfilter.RequestOption) yaormfilter.Filter {
+	f.AddOption_(opt)
+	return f
+}
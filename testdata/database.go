@@ -19,7 +19,7 @@ func init() {
 }
 
 var (
-	tables = []string{"category", "post", "post_tag", "tag"}
+	tables = []string{"category", "post", "post_tag", "tag", "article", "comment", "ticket", "task", "author", "book"}
 )
 
 func SetupTestDatabase(name string) (func(), error) {
@@ -2,6 +2,7 @@ package testdata
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/geoffreybauduin/yaorm"
 	"github.com/geoffreybauduin/yaorm/yaormfilter"
@@ -14,8 +15,13 @@ type Post struct {
 	CategoryID   int64           `db:"category_id"`
 	Category     *Category       `db:"-" filterload:"category,category_id"`
 	ParentPostID int64           `db:"parent_post_id"`
+	DeletedAt    *time.Time      `db:"deleted_at"`
 	ChildrenPost []*Post         `db:"-" filterload:"post,id,parent_post_id"`
+	ParentPost   *Post           `db:"-" filterload:"post,parent_post_id"`
 	Metadata     []*PostMetadata `db:"-" filterload:"post_metadata,id,post_id"`
+	Tags         []*Tag          `db:"-" filterload:"tag,id,post_tag,post_id,tag_id"`
+	// MetadataOnLoad records the number of metadata set on the post when DBHookAfterLoad was called
+	MetadataOnLoad int `db:"-"`
 }
 
 type PostFilter struct {
@@ -26,16 +32,22 @@ type PostFilter struct {
 	FilterCategory     yaormfilter.Filter      `filter:"category,join,id,category_id" filterload:"category"`
 	FilterChildren     yaormfilter.Filter      `filter:"post,join,parent_post_id,id" filterload:"post"`
 	FilterMetadata     []yaormfilter.Filter    `filter:"post_metadata,join,post_id,id" filterload:"post_metadata"`
+	FilterTags         []yaormfilter.Filter    `filter:"tag,join,id,id,post_tag,post_id,tag_id" filterload:"tag"`
 }
 
 func init() {
-	yaorm.NewTable("test", "post", &Post{}).WithFilter(&PostFilter{}).WithSubqueryloading(
+	yaorm.NewTable("test", "post", &Post{}).WithFilter(&PostFilter{}).WithSoftDelete("deleted_at").WithSubqueryloading(
 		func(dbp yaorm.DBProvider, ids []interface{}) (interface{}, error) {
 			return yaorm.GenericSelectAll(dbp, NewPostFilter().ParentPostID(yaormfilter.In(ids...)))
 		}, "parent_post_id",
 	)
 }
 
+func (p *Post) DBHookAfterLoad() error {
+	p.MetadataOnLoad = len(p.Metadata)
+	return nil
+}
+
 func NewPostFilter() *PostFilter {
 	return &PostFilter{}
 }
@@ -76,6 +88,21 @@ func (f *PostFilter) Subqueryload() yaormfilter.Filter {
 	return f
 }
 
+func (f *PostFilter) OrderBy(field string, way yaormfilter.OrderingWay) yaormfilter.Filter {
+	f.SetOrderBy(field, way)
+	return f
+}
+
+func (f *PostFilter) Limit(limit uint64) yaormfilter.Filter {
+	f.SetLimit(limit)
+	return f
+}
+
+func (f *PostFilter) Offset(offset uint64) yaormfilter.Filter {
+	f.SetOffset(offset)
+	return f
+}
+
 func (f *PostFilter) Metadata(metadata ...yaormfilter.Filter) *PostFilter {
 	if f.FilterMetadata == nil {
 		f.FilterMetadata = make([]yaormfilter.Filter, 0)
@@ -89,6 +116,16 @@ func (f *PostFilter) Metadata(metadata ...yaormfilter.Filter) *PostFilter {
 	return f
 }
 
+func (f *PostFilter) Tags(tags ...yaormfilter.Filter) *PostFilter {
+	for _, t := range tags {
+		if _, ok := t.(*TagFilter); !ok {
+			panic(fmt.Errorf("filter %v is not a TagFilter", t))
+		}
+		f.FilterTags = append(f.FilterTags, t)
+	}
+	return f
+}
+
 // AddOption adds an option on the current query
 func (f *PostFilter) AddOption(opt yaormfilter.RequestOption) yaormfilter.Filter {
 	f.AddOption_(opt)
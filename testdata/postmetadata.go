@@ -48,3 +48,18 @@ func (f *PostMetadataFilter) Subqueryload() yaormfilter.Filter {
 	f.AllowSubqueryload()
 	return f
 }
+
+func (f *PostMetadataFilter) OrderBy(field string, way yaormfilter.OrderingWay) yaormfilter.Filter {
+	f.SetOrderBy(field, way)
+	return f
+}
+
+func (f *PostMetadataFilter) Limit(limit uint64) yaormfilter.Filter {
+	f.SetLimit(limit)
+	return f
+}
+
+func (f *PostMetadataFilter) Offset(offset uint64) yaormfilter.Filter {
+	f.SetOffset(offset)
+	return f
+}
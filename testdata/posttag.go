@@ -9,10 +9,11 @@ import (
 
 type PostTag struct {
 	yaorm.DatabaseModel
-	PostID    int64     `db:"post_id"`
-	TagID     int64     `db:"tag_id"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	PostID    int64      `db:"post_id"`
+	TagID     int64      `db:"tag_id"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
 }
 
 type PostTagFilter struct {
@@ -22,7 +23,8 @@ type PostTagFilter struct {
 }
 
 func init() {
-	yaorm.NewTable("test", "post_tag", &PostTag{}).WithFilter(NewPostTagFilter()).WithKeys([]string{"post_id", "tag_id"}).WithAutoIncrement(false)
+	yaorm.NewTable("test", "post_tag", &PostTag{}).WithFilter(NewPostTagFilter()).WithKeys([]string{"post_id", "tag_id"}).WithAutoIncrement(false).
+		WithSoftDelete("deleted_at")
 }
 
 func (pt *PostTag) DBHookBeforeInsert() error {
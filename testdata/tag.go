@@ -34,3 +34,8 @@ func (f *TagFilter) Tag(v yaormfilter.ValueFilter) *TagFilter {
 	f.FilterTag = v
 	return f
 }
+
+func (f *TagFilter) Subqueryload() yaormfilter.Filter {
+	f.AllowSubqueryload()
+	return f
+}
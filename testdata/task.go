@@ -0,0 +1,45 @@
+package testdata
+
+import (
+	"time"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+// Task may belong to a category, and is kept without category when its category is deleted
+type Task struct {
+	yaorm.DatabaseModel
+	ID         int64      `db:"id"`
+	Title      string     `db:"title"`
+	CategoryID *int64     `db:"category_id"`
+	DeletedAt  *time.Time `db:"deleted_at"`
+}
+
+type TaskFilter struct {
+	yaormfilter.ModelFilter
+	FilterID         yaormfilter.ValueFilter `filter:"id"`
+	FilterCategoryID yaormfilter.ValueFilter `filter:"category_id"`
+}
+
+func init() {
+	yaorm.NewTable("test", "task", &Task{}).WithFilter(&TaskFilter{}).WithSoftDelete("deleted_at")
+}
+
+func (t *Task) Save() error {
+	return yaorm.GenericSave(t)
+}
+
+func NewTaskFilter() *TaskFilter {
+	return &TaskFilter{}
+}
+
+func (f *TaskFilter) ID(v yaormfilter.ValueFilter) *TaskFilter {
+	f.FilterID = v
+	return f
+}
+
+func (f *TaskFilter) CategoryID(v yaormfilter.ValueFilter) *TaskFilter {
+	f.FilterCategoryID = v
+	return f
+}
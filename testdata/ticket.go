@@ -0,0 +1,38 @@
+package testdata
+
+import (
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+)
+
+// Ticket has columns generated by the database: Reference has a default value,
+// and Revision is incremented by a trigger on every update
+type Ticket struct {
+	yaorm.DatabaseModel
+	ID        int64  `db:"id"`
+	Subject   string `db:"subject"`
+	Reference string `db:"reference" yaorm:"readonly"`
+	Revision  int64  `db:"revision" yaorm:"readonly"`
+}
+
+type TicketFilter struct {
+	yaormfilter.ModelFilter
+	FilterID yaormfilter.ValueFilter `filter:"id"`
+}
+
+func init() {
+	yaorm.NewTable("test", "ticket", &Ticket{}).WithFilter(&TicketFilter{})
+}
+
+func (t *Ticket) Save() error {
+	return yaorm.GenericSave(t)
+}
+
+func NewTicketFilter() *TicketFilter {
+	return &TicketFilter{}
+}
+
+func (f *TicketFilter) ID(v yaormfilter.ValueFilter) *TicketFilter {
+	f.FilterID = v
+	return f
+}
@@ -0,0 +1,323 @@
+package yaorm
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/juju/errors"
+)
+
+// throughKeyColumn is the name under which the fk of the original model is selected when loading a relation
+// through a join table
+const throughKeyColumn = "yaorm_through_key"
+
+// throughRelation is a many to many relation through a join table, declared on the model with
+// `filterload:"table,parentField,joinTable,joinTableParentField,joinTableField"`
+// like `filterload:"tag,id,post_tag,post_id,tag_id"`
+type throughRelation struct {
+	table         string
+	parentField   string
+	through       string
+	throughParent string
+	throughTarget string
+}
+
+func parseThroughRelation(tag string) (throughRelation, bool) {
+	tagData := strings.Split(tag, ",")
+	if len(tagData) != 5 {
+		return throughRelation{}, false
+	}
+	return throughRelation{
+		table:         tagData[0],
+		parentField:   tagData[1],
+		through:       tagData[2],
+		throughParent: tagData[3],
+		throughTarget: tagData[4],
+	}, true
+}
+
+// key returns the name of the relation used to subqueryload it
+func (r throughRelation) key() string {
+	return fmt.Sprintf("%s_per_%s.%s.%s", r.table, r.through, r.throughParent, r.throughTarget)
+}
+
+func throughRelationFromKey(key string) (throughRelation, bool) {
+	parts := strings.SplitN(key, "_per_", 2)
+	if len(parts) != 2 {
+		return throughRelation{}, false
+	}
+	through := strings.Split(parts[1], ".")
+	if len(through) != 3 {
+		return throughRelation{}, false
+	}
+	return throughRelation{
+		table:         parts[0],
+		through:       through[0],
+		throughParent: through[1],
+		throughTarget: through[2],
+	}, true
+}
+
+// targetTable returns the table of the related models, which must have a single primary key
+func (r throughRelation) targetTable(dbp DBProvider) (*Table, error) {
+	table, err := GetTable(dbp.dbName(), r.table)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.Keys()) != 1 {
+		return nil, errors.Errorf("Table %s must have a single primary key to be related through %s", r.table, r.through)
+	}
+	return table, nil
+}
+
+// loader returns the subqueryloader selecting the related models joined with the join table
+func (r throughRelation) loader(dbp DBProvider) (subqueryloader, error) {
+	table, err := r.targetTable(dbp)
+	if err != nil {
+		return subqueryloader{}, err
+	}
+	return subqueryloader{
+		through: func(dbp DBProvider, ids []interface{}) ([]Model, []interface{}, error) {
+			m, err := table.NewModel()
+			if err != nil {
+				return nil, nil, err
+			}
+			filter, err := table.NewFilter()
+			if err != nil {
+				return nil, nil, err
+			}
+			statement, err := buildSelect(dbp, m, buildSelectColumns{})
+			if err != nil {
+				return nil, nil, err
+			}
+			statement = statement.Column(fmt.Sprintf(
+				"%s.%s AS %s", dbp.EscapeValue(r.through), dbp.EscapeValue(r.throughParent), dbp.EscapeValue(throughKeyColumn),
+			)).Join(fmt.Sprintf(
+				"%s AS %s ON %s.%s = %s.%s",
+				throughTableName(dbp, r.through),
+				dbp.EscapeValue(r.through),
+				dbp.EscapeValue(r.through),
+				dbp.EscapeValue(r.throughTarget),
+				dbp.EscapeValue(table.Name()),
+				dbp.EscapeValue(table.Keys()[0]),
+			)).Where(squirrel.Eq{fmt.Sprintf("%s.%s", dbp.EscapeValue(r.through), dbp.EscapeValue(r.throughParent)): ids})
+			if condition := throughSoftDeleteCondition(dbp, r.through, r.through); condition != "" {
+				statement = statement.Where(condition)
+			}
+			query, params, err := apply(statement, filter, dbp).ToSql()
+			if err != nil {
+				return nil, nil, err
+			}
+			models := []Model{}
+			fks := []interface{}{}
+			fkType := reflect.TypeOf(ids[0])
+			var scanner *rowScanner
+			err = queryRows(dbp, false, query, params, func(rows *sql.Rows) error {
+				if scanner == nil {
+					columns, err := rows.Columns()
+					if err != nil {
+						return err
+					}
+					scanner, err = newRowScanner(dbp, table, nil, columns[:len(columns)-1])
+					if err != nil {
+						return err
+					}
+				}
+				m, err := table.NewModel()
+				if err != nil {
+					return err
+				}
+				fk := reflect.New(fkType)
+				err = scanner.scan(rows, m, fk.Interface())
+				if err != nil {
+					return err
+				}
+				models = append(models, m)
+				fks = append(fks, fk.Elem().Interface())
+				return nil
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			return models, fks, dbp.afterLoad(models)
+		},
+	}, nil
+}
+
+// throughTableName returns the name of the join table to use in a query,
+// with its schema when it is registered as a table
+func throughTableName(dbp DBProvider, name string) string {
+	if table, err := GetTable(dbp.dbName(), name); err == nil {
+		return table.NameForQuery(dbp)
+	}
+	return dbp.EscapeValue(name)
+}
+
+// getThroughRelation returns the relation through a join table declared on the model to the provided table
+func getThroughRelation(m Model, relation string) (throughRelation, error) {
+	_, tag := getFieldInModel(m, "filterload", relation)
+	r, ok := parseThroughRelation(tag)
+	if !ok {
+		return r, errors.Errorf("Cannot find a relation to %s through a join table in model %T", relation, m)
+	}
+	return r, nil
+}
+
+// GenericAttach links the models to m, inserting rows in the join table of their relation,
+// relation being the name of the table of the models, as declared in the filterload tag of m
+// When the join table is registered, its rows are inserted with GenericInsert, calling their hooks
+func GenericAttach(m Model, relation string, models ...Model) error {
+	r, err := getThroughRelation(m, relation)
+	if err != nil {
+		return err
+	}
+	dbp := m.GetDBP()
+	table, err := r.targetTable(dbp)
+	if err != nil {
+		return err
+	}
+	parentKey, err := throughParentKey(m, r)
+	if err != nil {
+		return err
+	}
+	throughTable, err := GetTable(dbp.dbName(), r.through)
+	if err != nil {
+		throughTable = nil
+	}
+	for _, related := range models {
+		key := tools.GetNonPtrValue(related).Field(table.FieldIndex(table.Keys()[0])).Interface()
+		if throughTable == nil {
+			query, args, err := dbp.getStatementGenerator().Insert(throughTableName(dbp, r.through)).Columns(
+				dbp.EscapeValue(r.throughParent), dbp.EscapeValue(r.throughTarget),
+			).Values(parentKey, key).ToSql()
+			if err != nil {
+				return err
+			}
+			_, err = dbp.DB().Exec(query, args...)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if throughTable.SoftDeleteField() != "" {
+			restored, err := restoreThroughLink(dbp, r, throughTable, parentKey, key)
+			if err != nil {
+				return err
+			}
+			if restored {
+				continue
+			}
+		}
+		link, err := throughTable.NewModel()
+		if err != nil {
+			return err
+		}
+		reflectedLink := tools.GetNonPtrValue(link)
+		for field, value := range map[string]interface{}{r.throughParent: parentKey, r.throughTarget: key} {
+			idx := throughTable.FieldIndex(field)
+			if idx < 0 {
+				return errors.Errorf("Cannot find field %s in model %s", field, throughTable.Name())
+			}
+			fieldType := reflectedLink.Field(idx).Type()
+			if !reflect.TypeOf(value).ConvertibleTo(fieldType) {
+				return errors.Errorf("Cannot set %T to field %s of model %s, expecting %s", value, field, throughTable.Name(), fieldType)
+			}
+			reflectedLink.Field(idx).Set(reflect.ValueOf(value).Convert(fieldType))
+		}
+		link.SetDBP(dbp)
+		err = GenericInsert(link)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreThroughLink restores the soft deleted row of the join table linking the keys, returning whether it existed
+func restoreThroughLink(dbp DBProvider, r throughRelation, throughTable *Table, parentKey, key interface{}) (bool, error) {
+	field := dbp.EscapeValue(throughTable.SoftDeleteField())
+	query, args, err := dbp.getStatementGenerator().Update(throughTable.NameForQuery(dbp)).Set(field, nil).Where(squirrel.Eq{
+		dbp.EscapeValue(r.throughParent): parentKey,
+		dbp.EscapeValue(r.throughTarget): key,
+	}).Where(squirrel.NotEq{field: nil}).ToSql()
+	if err != nil {
+		return false, err
+	}
+	res, err := dbp.DB().Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// throughSoftDeleteCondition returns the condition excluding the soft deleted rows of the join table,
+// when it is registered with soft delete
+func throughSoftDeleteCondition(dbp DBProvider, name string, alias string) string {
+	table, err := GetTable(dbp.dbName(), name)
+	if err != nil || table.SoftDeleteField() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s IS NULL", dbp.EscapeValue(alias), dbp.EscapeValue(table.SoftDeleteField()))
+}
+
+// GenericDetach unlinks the models from m, deleting the rows of the join table of their relation,
+// relation being the name of the table of the models, as declared in the filterload tag of m
+// When the join table is registered with soft delete, its rows are flagged as deleted instead
+// Every model of the relation is detached when no model is provided
+func GenericDetach(m Model, relation string, models ...Model) (int64, error) {
+	r, err := getThroughRelation(m, relation)
+	if err != nil {
+		return 0, err
+	}
+	dbp := m.GetDBP()
+	table, err := r.targetTable(dbp)
+	if err != nil {
+		return 0, err
+	}
+	parentKey, err := throughParentKey(m, r)
+	if err != nil {
+		return 0, err
+	}
+	conditions := squirrel.Eq{dbp.EscapeValue(r.throughParent): parentKey}
+	if len(models) > 0 {
+		keys := make([]interface{}, 0, len(models))
+		for _, related := range models {
+			keys = append(keys, tools.GetNonPtrValue(related).Field(table.FieldIndex(table.Keys()[0])).Interface())
+		}
+		conditions[dbp.EscapeValue(r.throughTarget)] = keys
+	}
+	var query string
+	var args []interface{}
+	if throughTable, err := GetTable(dbp.dbName(), r.through); err == nil && throughTable.SoftDeleteField() != "" {
+		field := dbp.EscapeValue(throughTable.SoftDeleteField())
+		query, args, err = dbp.getStatementGenerator().Update(throughTableName(dbp, r.through)).Set(
+			field, dbp.Now(),
+		).Where(conditions).Where(squirrel.Eq{field: nil}).ToSql()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		query, args, err = dbp.getStatementGenerator().Delete(throughTableName(dbp, r.through)).Where(conditions).ToSql()
+		if err != nil {
+			return 0, err
+		}
+	}
+	res, err := dbp.DB().Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func throughParentKey(m Model, r throughRelation) (interface{}, error) {
+	idx, _ := getFieldInModel(m, "db", r.parentField)
+	if idx < 0 {
+		return nil, errors.Errorf("Cannot find field %s in model %T", r.parentField, m)
+	}
+	return reflect.Indirect(tools.GetNonPtrValue(m).Field(idx)).Interface(), nil
+}
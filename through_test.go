@@ -0,0 +1,102 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThroughRelation(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	category := &testdata.Category{Name: "category"}
+	saveModel(t, dbp, category)
+	first := &testdata.Post{Subject: "first", CategoryID: category.ID}
+	saveModel(t, dbp, first)
+	second := &testdata.Post{Subject: "second", CategoryID: category.ID}
+	saveModel(t, dbp, second)
+	golang := &testdata.Tag{Tag: "golang"}
+	saveModel(t, dbp, golang)
+	sql := &testdata.Tag{Tag: "sql"}
+	saveModel(t, dbp, sql)
+
+	assert.NoError(t, yaorm.GenericAttach(first, "tag", golang, sql))
+	assert.NoError(t, yaorm.GenericAttach(second, "tag", sql))
+
+	// the join table is registered, the links have been inserted with their hooks
+	links, err := yaorm.GenericSelectAll(dbp, testdata.NewPostTagFilter().PostID(yaormfilter.Equals(first.ID)))
+	assert.NoError(t, err)
+	if assert.Len(t, links, 2) {
+		assert.False(t, links[0].(*testdata.PostTag).CreatedAt.IsZero())
+	}
+
+	models, err := yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Tags(
+		testdata.NewTagFilter().Tag(yaormfilter.Equals("golang")),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		assert.Equal(t, first.ID, models[0].(*testdata.Post).ID)
+	}
+
+	post, err := yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(first.ID)).Tags(
+		testdata.NewTagFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	tags := map[string]bool{}
+	for _, tag := range post.(*testdata.Post).Tags {
+		tags[tag.Tag] = true
+		assert.Equal(t, dbp, tag.GetDBP())
+	}
+	assert.Equal(t, map[string]bool{"golang": true, "sql": true}, tags)
+
+	rows, err := yaorm.GenericDetach(first, "tag", sql)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	post, err = yaorm.GenericSelectOne(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(first.ID)).Tags(
+		testdata.NewTagFilter().Subqueryload(),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, post.(*testdata.Post).Tags, 1) {
+		assert.Equal(t, "golang", post.(*testdata.Post).Tags[0].Tag)
+	}
+
+	// the join table is soft deleted, the detached link is kept and restored when attached again
+	deleted := testdata.NewPostTagFilter().PostID(yaormfilter.Equals(first.ID)).TagID(yaormfilter.Equals(sql.ID))
+	deleted.OnlyDeleted()
+	links, err = yaorm.GenericSelectAll(dbp, deleted)
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+	rows, err = yaorm.GenericDetach(first, "tag", sql)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+	assert.NoError(t, yaorm.GenericAttach(first, "tag", sql))
+	links, err = yaorm.GenericSelectAll(dbp, testdata.NewPostTagFilter().PostID(yaormfilter.Equals(first.ID)))
+	assert.NoError(t, err)
+	assert.Len(t, links, 2)
+	models, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Tags(
+		testdata.NewTagFilter().Tag(yaormfilter.Equals("sql")),
+	))
+	assert.NoError(t, err)
+	assert.Len(t, models, 2)
+
+	rows, err = yaorm.GenericDetach(second, "tag")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	models, err = yaorm.GenericSelectAll(dbp, testdata.NewPostFilter().Tags(
+		testdata.NewTagFilter().Tag(yaormfilter.Equals("sql")),
+	))
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		assert.Equal(t, first.ID, models[0].(*testdata.Post).ID)
+	}
+
+	_, err = yaorm.GenericDetach(first, "category")
+	assert.Error(t, err)
+}
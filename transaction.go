@@ -0,0 +1,234 @@
+package yaorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/loopfz/gadgeto/zesty"
+	"github.com/go-gorp/gorp"
+	"github.com/juju/errors"
+)
+
+// transactionDB is the database of a DBProvider, beginning its transactions with the options
+// requested by RunInTransactionWithOptions, which zesty and gorp do not provide
+type transactionDB struct {
+	zesty.DB
+	dbp *dbprovider
+}
+
+// Begin begins a transaction with the options of the DBProvider
+// the isolation level and the access mode are set by the driver, DEFERRABLE being set by a first statement
+// on PostgreSQL and ignored by the other databases
+func (d *transactionDB) Begin() (zesty.Tx, error) {
+	opts := d.dbp.txOptions
+	if opts.isDefault() {
+		return d.DB.Begin()
+	}
+	database, ok := d.dbp.getDb().(*db)
+	if !ok {
+		return nil, errors.Errorf("Cannot begin a transaction with options on database %s", d.dbp.name)
+	}
+	switch opts.Isolation {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable:
+	default:
+		return nil, errors.Errorf("Unsupported isolation level %s", opts.Isolation)
+	}
+	if opts.ReadOnly && database.System() == DatabaseSqlite3 {
+		// the driver ignores the access mode, SQLite transactions being always serializable
+		return nil, errors.Errorf("Read only transactions are not supported by SQLite")
+	}
+	tx, err := database.dbmap.Db.BeginTx(d.dbp.Context(), &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Deferrable && database.System() == DatabasePostgreSQL {
+		if _, err := tx.Exec("SET TRANSACTION DEFERRABLE"); err != nil {
+			tx.Rollback() //nolint:errcheck
+			return nil, err
+		}
+	}
+	transaction, err := newTransaction(database.dbmap, tx)
+	if err != nil {
+		tx.Rollback() //nolint:errcheck
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// transaction is a transaction begun with options, gorp only beginning transactions without options:
+// a gorp transaction of a copy of the DbMap running its statements through the transaction of database/sql
+type transaction struct {
+	*gorp.Transaction
+	db *sql.DB
+}
+
+// newTransaction wraps a transaction begun with database/sql in a transaction of a copy of the gorp DbMap
+func newTransaction(dbmap *gorp.DbMap, tx *sql.Tx) (*transaction, error) {
+	db := sql.OpenDB(&txConnector{tx: tx})
+	txDbmap := *dbmap
+	txDbmap.Db = db
+	gorpTx, err := txDbmap.Begin()
+	if err != nil {
+		db.Close() //nolint:errcheck
+		return nil, err
+	}
+	return &transaction{Transaction: gorpTx, db: db}, nil
+}
+
+// Commit commits the transaction and releases its database
+func (t *transaction) Commit() error {
+	defer t.db.Close() //nolint:errcheck
+	return t.Transaction.Commit()
+}
+
+// Rollback rolls back the transaction and releases its database
+func (t *transaction) Rollback() error {
+	defer t.db.Close() //nolint:errcheck
+	return t.Transaction.Rollback()
+}
+
+// txConnector connects to a transaction of database/sql, so that a database opened with it
+// runs its statements, and commits or rolls back its transaction, through that transaction
+type txConnector struct {
+	tx *sql.Tx
+}
+
+func (c *txConnector) Connect(context.Context) (driver.Conn, error) {
+	return &txConn{tx: c.tx}, nil
+}
+
+func (c *txConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c *txConnector) Open(string) (driver.Conn, error) {
+	return &txConn{tx: c.tx}, nil
+}
+
+// txConn is a connection running its statements through a transaction of database/sql
+type txConn struct {
+	tx *sql.Tx
+}
+
+func (c *txConn) Prepare(query string) (driver.Stmt, error) {
+	return &txStmt{conn: c, query: query}, nil
+}
+
+func (c *txConn) Close() error {
+	return nil
+}
+
+// Begin returns the transaction the connection runs through, it is committed or rolled back along with it
+func (c *txConn) Begin() (driver.Tx, error) {
+	return c.tx, nil
+}
+
+// CheckNamedValue leaves the arguments untouched, they are converted by the transaction running the statements
+func (c *txConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.tx.ExecContext(ctx, query, txArgs(args)...)
+}
+
+func (c *txConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.tx.QueryContext(ctx, query, txArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close() //nolint:errcheck
+		return nil, err
+	}
+	return &txRows{rows: rows, columns: columns}, nil
+}
+
+// txArgs returns the arguments of a statement as expected by database/sql
+func txArgs(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			values[i] = sql.Named(arg.Name, arg.Value)
+		} else {
+			values[i] = arg.Value
+		}
+	}
+	return values
+}
+
+// txStmt is a statement of a txConn, run through the transaction when executed
+type txStmt struct {
+	conn  *txConn
+	query string
+}
+
+func (s *txStmt) Close() error {
+	return nil
+}
+
+func (s *txStmt) NumInput() int {
+	return -1
+}
+
+func (s *txStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *txStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *txStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *txStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+// txRows are the rows returned by a query run through the transaction
+type txRows struct {
+	rows    *sql.Rows
+	columns []string
+}
+
+func (r *txRows) Columns() []string {
+	return r.columns
+}
+
+func (r *txRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *txRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	values := make([]interface{}, len(dest))
+	pointers := make([]interface{}, len(dest))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := r.rows.Scan(pointers...); err != nil {
+		return err
+	}
+	for i, value := range values {
+		dest[i] = value
+	}
+	return nil
+}
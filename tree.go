@@ -0,0 +1,206 @@
+package yaorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/geoffreybauduin/yaorm/_vendor/github.com/lann/squirrel"
+	"github.com/geoffreybauduin/yaorm/tools"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/juju/errors"
+)
+
+const (
+	// treeTable is the name of the common table expression holding the nodes of a tree
+	treeTable = "yaorm_tree"
+	// treeDepthColumn is the name of the column holding the depth of a node below the selected models
+	treeDepthColumn = "yaorm_depth"
+)
+
+// GenericSelectTree selects the models matching the filter, along with all their descendants in a single recursive query.
+// parentColumn is the column referencing the parent of a model, and the children are set on the slice field
+// declared with `filterload:"table,key,parentColumn"`, like `filterload:"post,id,parent_post_id"`.
+// maxDepth limits the number of levels loaded below the selected models, 0 loading the whole subtrees,
+// which must not contain any cycle then
+// panics if filter or dbp is nil
+func GenericSelectTree(dbp DBProvider, filter yaormfilter.Filter, parentColumn string, maxDepth int) ([]Model, error) {
+	table, roots, err := selectTreeRoots(dbp, filter, parentColumn)
+	if err != nil {
+		return nil, err
+	}
+	key := table.Keys()[0]
+	fieldIndex, err := treeRelationField(table, table.Name(), key, parentColumn)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := selectTreeNodes(dbp, table, filter, parentColumn, key, treeValues(table, roots, key), maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	byKey := indexTreeNodes(table, key, roots, nodes)
+	// a node reached from several selected models is only set once on its parent
+	wired := map[interface{}]bool{}
+	for _, node := range nodes {
+		nodeKey := treeValue(table, node, key)
+		parent, ok := byKey[treeValue(table, node, parentColumn)]
+		if !ok || wired[nodeKey] {
+			continue
+		}
+		wired[nodeKey] = true
+		setOnReceiver(tools.GetNonPtrValue(parent).Field(fieldIndex).Addr(), byKey[nodeKey])
+	}
+	return roots, nil
+}
+
+// GenericSelectAncestors selects the models matching the filter, along with all their ancestors in a single recursive query.
+// parentColumn is the column referencing the parent of a model, and the parent is set on the pointer field
+// declared with `filterload:"table,parentColumn"`, like `filterload:"post,parent_post_id"`.
+// maxDepth limits the number of ancestors loaded above the selected models, 0 loading them up to the roots,
+// and the chain must not contain any cycle then
+// panics if filter or dbp is nil
+func GenericSelectAncestors(dbp DBProvider, filter yaormfilter.Filter, parentColumn string, maxDepth int) ([]Model, error) {
+	table, models, err := selectTreeRoots(dbp, filter, parentColumn)
+	if err != nil {
+		return nil, err
+	}
+	key := table.Keys()[0]
+	fieldIndex, err := treeRelationField(table, table.Name(), parentColumn)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := selectTreeNodes(dbp, table, filter, key, parentColumn, treeValues(table, models, parentColumn), maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	byKey := indexTreeNodes(table, key, models, nodes)
+	for _, m := range byKey {
+		parent, ok := byKey[treeValue(table, m, parentColumn)]
+		if !ok {
+			continue
+		}
+		tools.GetNonPtrValue(m).Field(fieldIndex).Set(reflect.ValueOf(parent))
+	}
+	return models, nil
+}
+
+// selectTreeRoots selects the models the tree starts from, checking the table can be loaded as a tree
+func selectTreeRoots(dbp DBProvider, filter yaormfilter.Filter, parentColumn string) (*Table, []Model, error) {
+	table, err := GetTableByFilter(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(table.Keys()) != 1 {
+		return nil, nil, errors.Errorf("Table %s must have a single primary key to be loaded as a tree", table.Name())
+	}
+	if table.FieldIndex(parentColumn) < 0 {
+		return nil, nil, errors.Errorf("Cannot find field %s in table %s", parentColumn, table.Name())
+	}
+	if !dbp.HasCapacity(DatabaseCapacityRecursiveQueries) {
+		return nil, nil, errors.Errorf("Database does not provide recursive queries, table %s cannot be loaded as a tree", table.Name())
+	}
+	models, err := GenericSelectAll(dbp, filter)
+	return table, models, err
+}
+
+// treeRelationField returns the index of the field of the model declared with the provided filterload tag
+func treeRelationField(table *Table, tag ...string) (int, error) {
+	for i := 0; i < table.reflectedType.NumField(); i++ {
+		if table.reflectedType.Field(i).Tag.Get("filterload") == strings.Join(tag, ",") {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("Cannot find field declared with filterload:\"%s\" in model %s", strings.Join(tag, ","), table.Name())
+}
+
+// selectTreeNodes selects recursively the rows whose column matches one of the values, and then the ones
+// whose column matches the otherColumn of a row already selected, down to maxDepth levels
+// the soft deleted rows are selected as requested by the options of the filter
+func selectTreeNodes(dbp DBProvider, table *Table, filter yaormfilter.Filter, column, otherColumn string, values []interface{}, maxDepth int) ([]Model, error) {
+	if len(values) == 0 {
+		return []Model{}, nil
+	}
+	fields := make([]string, 0, len(table.Fields()))
+	treeFields := make([]string, 0, len(table.Fields()))
+	for _, field := range table.Fields() {
+		fields = append(fields, fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(field)))
+		treeFields = append(treeFields, fmt.Sprintf("%s.%s", dbp.EscapeValue(treeTable), dbp.EscapeValue(field)))
+	}
+	from := fmt.Sprintf("%s AS %s", table.NameForQuery(dbp), dbp.EscapeValue(table.Name()))
+	depth := fmt.Sprintf("%s.%s", dbp.EscapeValue(treeTable), dbp.EscapeValue(treeDepthColumn))
+	anchor := squirrel.Select(fields...).Column("1").From(from).Where(squirrel.Eq{
+		fmt.Sprintf("%s.%s", dbp.EscapeValue(table.Name()), dbp.EscapeValue(column)): values,
+	})
+	recursive := squirrel.Select(fields...).Column(fmt.Sprintf("%s + 1", depth)).From(from).Join(fmt.Sprintf(
+		"%s ON %s.%s = %s.%s",
+		dbp.EscapeValue(treeTable),
+		dbp.EscapeValue(table.Name()), dbp.EscapeValue(column),
+		dbp.EscapeValue(treeTable), dbp.EscapeValue(otherColumn),
+	))
+	if maxDepth > 0 {
+		recursive = recursive.Where(fmt.Sprintf("%s < ?", depth), maxDepth)
+	}
+	if condition := softDeleteCondition(dbp, table, filter, table.Name()); condition != "" {
+		anchor = anchor.Where(condition)
+		recursive = recursive.Where(condition)
+	}
+	anchorQuery, anchorArgs, err := anchor.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	recursiveQuery, recursiveArgs, err := recursive.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, 0, len(table.Fields())+1)
+	for _, field := range table.Fields() {
+		columns = append(columns, dbp.EscapeValue(field))
+	}
+	columns = append(columns, dbp.EscapeValue(treeDepthColumn))
+	statement := dbp.getStatementGenerator().Select(treeFields...).Prefix(fmt.Sprintf(
+		"WITH RECURSIVE %s (%s) AS (%s UNION ALL %s)",
+		dbp.EscapeValue(treeTable), strings.Join(columns, ", "), anchorQuery, recursiveQuery,
+	), append(anchorArgs, recursiveArgs...)...).From(dbp.EscapeValue(treeTable)).OrderBy(
+		depth, fmt.Sprintf("%s.%s", dbp.EscapeValue(treeTable), dbp.EscapeValue(table.Keys()[0])),
+	)
+	return selectModels(dbp, table, statement)
+}
+
+// treeValue returns the value of the column of the model
+func treeValue(table *Table, m Model, column string) interface{} {
+	value := reflect.Indirect(tools.GetNonPtrValue(m).Field(table.FieldIndex(column)))
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// treeValues returns the distinct values of the column of the models, ignoring zero values
+func treeValues(table *Table, models []Model, column string) []interface{} {
+	values := []interface{}{}
+	seen := map[interface{}]bool{}
+	for _, m := range models {
+		field := tools.GetNonPtrValue(m).Field(table.FieldIndex(column))
+		if tools.IsZeroValue(field) {
+			continue
+		}
+		value := reflect.Indirect(field).Interface()
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// indexTreeNodes indexes the models per primary key, the selected ones taking precedence
+func indexTreeNodes(table *Table, key string, models []Model, nodes []Model) map[interface{}]Model {
+	byKey := map[interface{}]Model{}
+	for _, node := range nodes {
+		byKey[treeValue(table, node, key)] = node
+	}
+	for _, m := range models {
+		byKey[treeValue(table, m, key)] = m
+	}
+	return byKey
+}
@@ -0,0 +1,108 @@
+package yaorm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geoffreybauduin/yaorm"
+	"github.com/geoffreybauduin/yaorm/testdata"
+	"github.com/geoffreybauduin/yaorm/yaormfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupThread saves a thread of posts: root > (a > (a1 > a11), b)
+func setupThread(t *testing.T, dbp yaorm.DBProvider) map[string]*testdata.Post {
+	posts := map[string]*testdata.Post{}
+	for _, node := range [][2]string{{"root", ""}, {"a", "root"}, {"b", "root"}, {"a1", "a"}, {"a11", "a1"}} {
+		post := &testdata.Post{Subject: node[0]}
+		if parent, ok := posts[node[1]]; ok {
+			post.ParentPostID = parent.ID
+		}
+		saveModel(t, dbp, post)
+		posts[node[0]] = post
+	}
+	return posts
+}
+
+func TestGenericSelectTree(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	posts := setupThread(t, dbp)
+
+	models, err := yaorm.GenericSelectTree(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(posts["root"].ID)), "parent_post_id", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		root := models[0].(*testdata.Post)
+		assert.Equal(t, "root", root.Subject)
+		if assert.Len(t, root.ChildrenPost, 2) {
+			assert.Equal(t, "a", root.ChildrenPost[0].Subject)
+			assert.Equal(t, "b", root.ChildrenPost[1].Subject)
+			assert.Len(t, root.ChildrenPost[1].ChildrenPost, 0)
+			a := root.ChildrenPost[0]
+			if assert.Len(t, a.ChildrenPost, 1) && assert.Len(t, a.ChildrenPost[0].ChildrenPost, 1) {
+				assert.Equal(t, "a11", a.ChildrenPost[0].ChildrenPost[0].Subject)
+				assert.Equal(t, dbp, a.ChildrenPost[0].ChildrenPost[0].GetDBP())
+			}
+		}
+	}
+
+	models, err = yaorm.GenericSelectTree(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(posts["root"].ID)), "parent_post_id", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) && assert.Len(t, models[0].(*testdata.Post).ChildrenPost, 2) {
+		a := models[0].(*testdata.Post).ChildrenPost[0]
+		if assert.Len(t, a.ChildrenPost, 1) {
+			assert.Len(t, a.ChildrenPost[0].ChildrenPost, 0)
+		}
+	}
+
+	// soft deleted nodes are selected as requested by the filter
+	_, err = yaorm.GenericDelete(posts["a1"])
+	assert.NoError(t, err)
+	models, err = yaorm.GenericSelectTree(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(posts["a"].ID)), "parent_post_id", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		assert.Len(t, models[0].(*testdata.Post).ChildrenPost, 0)
+	}
+	withDeleted := testdata.NewPostFilter().ID(yaormfilter.Equals(posts["a"].ID))
+	withDeleted.WithDeleted()
+	models, err = yaorm.GenericSelectTree(dbp, withDeleted, "parent_post_id", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) && assert.Len(t, models[0].(*testdata.Post).ChildrenPost, 1) {
+		assert.Equal(t, "a1", models[0].(*testdata.Post).ChildrenPost[0].Subject)
+		assert.Len(t, models[0].(*testdata.Post).ChildrenPost[0].ChildrenPost, 1)
+	}
+
+	_, err = yaorm.GenericSelectTree(dbp, testdata.NewPostFilter(), "subject_id", 0)
+	assert.EqualError(t, err, "Cannot find field subject_id in table post")
+	_, err = yaorm.GenericSelectTree(dbp, testdata.NewPostFilter(), "category_id", 0)
+	assert.EqualError(t, err, `Cannot find field declared with filterload:"post,id,category_id" in model post`)
+}
+
+func TestGenericSelectAncestors(t *testing.T) {
+	killDb, err := testdata.SetupTestDatabase("test")
+	defer killDb()
+	assert.NoError(t, err)
+	dbp, err := yaorm.NewDBProvider(context.TODO(), "test")
+	assert.NoError(t, err)
+	posts := setupThread(t, dbp)
+
+	models, err := yaorm.GenericSelectAncestors(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(posts["a11"].ID)), "parent_post_id", 0)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) {
+		subjects := []string{}
+		for post := models[0].(*testdata.Post); post != nil; post = post.ParentPost {
+			subjects = append(subjects, post.Subject)
+		}
+		assert.Equal(t, []string{"a11", "a1", "a", "root"}, subjects)
+	}
+
+	models, err = yaorm.GenericSelectAncestors(dbp, testdata.NewPostFilter().ID(yaormfilter.Equals(posts["a11"].ID)), "parent_post_id", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, models, 1) && assert.NotNil(t, models[0].(*testdata.Post).ParentPost) {
+		assert.Equal(t, "a1", models[0].(*testdata.Post).ParentPost.Subject)
+		assert.Nil(t, models[0].(*testdata.Post).ParentPost.ParentPost)
+	}
+}
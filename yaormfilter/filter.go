@@ -20,6 +20,21 @@ type Filter interface {
 	GetDontLoadColumns() []string
 }
 
+// CountFilterer can be implemented by a filter to load, or filter on, the number of related rows of relations,
+// as ModelFilter does
+type CountFilterer interface {
+	// GetCounts returns the relations whose number of related rows is loaded into the models
+	GetCounts() []string
+	// GetCountFilters returns the filters on the number of related rows of relations
+	GetCountFilters() []*CountFilter
+}
+
+// AliasedFilter can be implemented by a filter to name the alias of the table it joins, as ModelFilter does
+type AliasedFilter interface {
+	// GetAlias returns the alias of the joined table, or an empty string to use the generated one
+	GetAlias() string
+}
+
 // OrderingWay is a custom type to have ordering
 type OrderingWay string
 
@@ -40,10 +55,28 @@ var RequestOptions = struct {
 	SelectForUpdate RequestOption
 	SelectDistinct  RequestOption
 	LeftJoin        RequestOption
+	WithDeleted     RequestOption
+	OnlyDeleted     RequestOption
+	Join            RequestOption
+	Joinload        RequestOption
+	RightJoin       RequestOption
+	FullJoin        RequestOption
+	SelectForShare  RequestOption
+	NoWait          RequestOption
+	SkipLocked      RequestOption
 }{
 	SelectForUpdate: "SelectForUpdate",
 	SelectDistinct:  "SelectDistinct",
 	LeftJoin:        "LeftJoin",
+	WithDeleted:     "WithDeleted",
+	OnlyDeleted:     "OnlyDeleted",
+	Join:            "Join",
+	Joinload:        "Joinload",
+	RightJoin:       "RightJoin",
+	FullJoin:        "FullJoin",
+	SelectForShare:  "SelectForShare",
+	NoWait:          "NoWait",
+	SkipLocked:      "SkipLocked",
 }
 
 // ModelFilter is the struct every filter should compose
@@ -57,11 +90,22 @@ type ModelFilter struct {
 	offset          uint64
 	loadColumns     []string
 	dontLoadColumns []string
+	counts          []string
+	countFilters    []*CountFilter
+	alias           string
 }
 
 type OrderBy struct {
 	Field string
 	Way   OrderingWay
+	// Count is true when ordering by the number of related rows of the relation Field
+	Count bool
+}
+
+// CountFilter filters on the number of related rows of a relation
+type CountFilter struct {
+	Relation string
+	Filter   ValueFilter
 }
 
 func (mf *ModelFilter) Subqueryload() Filter {
@@ -89,7 +133,7 @@ func (mf *ModelFilter) SetOrderBy(field string, way OrderingWay) Filter {
 	if mf.orderBy == nil {
 		mf.orderBy = []*OrderBy{}
 	}
-	mf.orderBy = append(mf.orderBy, &OrderBy{field, way})
+	mf.orderBy = append(mf.orderBy, &OrderBy{Field: field, Way: way})
 	return mf
 }
 
@@ -105,7 +149,11 @@ func (mf *ModelFilter) GetSelectOptions() []RequestOption {
 	opts := []RequestOption{}
 	for _, opt := range mf.options {
 		switch opt {
-		case RequestOptions.SelectForUpdate, RequestOptions.SelectDistinct:
+		case RequestOptions.SelectForUpdate, RequestOptions.SelectDistinct,
+			RequestOptions.WithDeleted, RequestOptions.OnlyDeleted, RequestOptions.Join,
+			RequestOptions.Joinload, RequestOptions.LeftJoin, RequestOptions.RightJoin,
+			RequestOptions.FullJoin, RequestOptions.SelectForShare, RequestOptions.NoWait,
+			RequestOptions.SkipLocked:
 			opts = append(opts, opt)
 		}
 	}
@@ -116,6 +164,74 @@ func (mf *ModelFilter) Distinct() {
 	mf.AddOption_("SelectDistinct")
 }
 
+// ForUpdate locks the selected rows for update until the end of the transaction
+func (mf *ModelFilter) ForUpdate() {
+	mf.AddOption_(RequestOptions.SelectForUpdate)
+}
+
+// ForShare locks the selected rows against updates until the end of the transaction, letting other
+// transactions lock them for share too
+func (mf *ModelFilter) ForShare() {
+	mf.AddOption_(RequestOptions.SelectForShare)
+}
+
+// NoWait fails the query instead of waiting when a row to lock is already locked
+func (mf *ModelFilter) NoWait() {
+	mf.AddOption_(RequestOptions.NoWait)
+}
+
+// SkipLocked skips the rows already locked instead of waiting for them
+func (mf *ModelFilter) SkipLocked() {
+	mf.AddOption_(RequestOptions.SkipLocked)
+}
+
+// WithDeleted includes the soft deleted rows in the results
+func (mf *ModelFilter) WithDeleted() {
+	mf.AddOption_(RequestOptions.WithDeleted)
+}
+
+// OnlyDeleted restricts the results to the soft deleted rows
+func (mf *ModelFilter) OnlyDeleted() {
+	mf.AddOption_(RequestOptions.OnlyDeleted)
+}
+
+// Join renders the join on this filter even when it has no condition,
+// allowing to select the columns of the joined table
+func (mf *ModelFilter) Join() {
+	mf.AddOption_(RequestOptions.Join)
+}
+
+// Joinload loads the to-one relation filtered by this filter from the join, in the same query as its parent,
+// instead of subqueryloading it
+func (mf *ModelFilter) Joinload() {
+	mf.AddOption_(RequestOptions.Joinload)
+}
+
+// LeftJoin renders the join on this filter as a LEFT JOIN, its conditions being placed in the ON clause
+// so that the rows of the parent table without any matching row are kept
+func (mf *ModelFilter) LeftJoin() {
+	mf.AddOption_(RequestOptions.LeftJoin)
+}
+
+// RightJoin renders the join on this filter as a RIGHT JOIN, even when it has no condition
+func (mf *ModelFilter) RightJoin() {
+	mf.AddOption_(RequestOptions.RightJoin)
+}
+
+// FullJoin renders the join on this filter as a FULL OUTER JOIN, even when it has no condition
+func (mf *ModelFilter) FullJoin() {
+	mf.AddOption_(RequestOptions.FullJoin)
+}
+
+// As names the alias of the table joined by this filter, instead of the one generated from the table names
+func (mf *ModelFilter) As(alias string) {
+	mf.alias = alias
+}
+
+func (mf *ModelFilter) GetAlias() string {
+	return mf.alias
+}
+
 func (mf *ModelFilter) Limit(limit uint64) Filter {
 	panic(errors.NotImplementedf("Limit"))
 }
@@ -157,3 +273,30 @@ func (mf *ModelFilter) DontLoadColumns(columns ...string) {
 func (mf *ModelFilter) GetDontLoadColumns() []string {
 	return mf.dontLoadColumns[:len(mf.dontLoadColumns):len(mf.dontLoadColumns)]
 }
+
+// WithCount loads the number of related rows of the relations into the fields of the model declared with
+// `count:"relation,fk"` tags, without loading the related rows
+func (mf *ModelFilter) WithCount(relations ...string) {
+	mf.counts = append(mf.counts, relations...)
+}
+
+func (mf *ModelFilter) GetCounts() []string {
+	return mf.counts[:len(mf.counts):len(mf.counts)]
+}
+
+// FilterCount filters the models on the number of related rows of the relation
+func (mf *ModelFilter) FilterCount(relation string, v ValueFilter) {
+	mf.countFilters = append(mf.countFilters, &CountFilter{Relation: relation, Filter: v})
+}
+
+func (mf *ModelFilter) GetCountFilters() []*CountFilter {
+	return mf.countFilters[:len(mf.countFilters):len(mf.countFilters)]
+}
+
+// OrderByCount orders the models by the number of related rows of the relation
+func (mf *ModelFilter) OrderByCount(relation string, way OrderingWay) {
+	if mf.orderBy == nil {
+		mf.orderBy = []*OrderBy{}
+	}
+	mf.orderBy = append(mf.orderBy, &OrderBy{Field: relation, Way: way, Count: true})
+}
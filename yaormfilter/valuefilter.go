@@ -26,6 +26,13 @@ type ValueFilter interface {
 	GetEquality() interface{}
 }
 
+// ExpressionFilter is implemented by the value filters which can apply on any SQL expression, like the number
+// of related rows filtered with ModelFilter.FilterCount
+type ExpressionFilter interface {
+	ApplyOnExpression(statement squirrel.SelectBuilder, expression string) squirrel.SelectBuilder
+	Predicate(expression string) interface{}
+}
+
 type valuefilterimpl struct {
 	filterFn    RawFilterFunc
 	shouldEqual bool
@@ -116,11 +123,22 @@ func (f *valuefilterimpl) raw(fn RawFilterFunc) *valuefilterimpl {
 }
 
 func (f *valuefilterimpl) Apply(statement squirrel.SelectBuilder, tableName, fieldName string) squirrel.SelectBuilder {
-	computedField := fmt.Sprintf(`%s.%s`, tableName, fieldName)
-	if f.filterFn != nil {
-		statement = statement.Where(
-			f.filterFn(computedField),
-		)
+	return f.ApplyOnExpression(statement, fmt.Sprintf(`%s.%s`, tableName, fieldName))
+}
+
+// ApplyOnExpression applies the filter on any SQL expression instead of a column
+func (f *valuefilterimpl) ApplyOnExpression(statement squirrel.SelectBuilder, expression string) squirrel.SelectBuilder {
+	if pred := f.Predicate(expression); pred != nil {
+		statement = statement.Where(pred)
 	}
 	return statement
 }
+
+// Predicate returns the condition of the filter on the expression, as accepted by squirrel's Where,
+// or nil when the filter has no condition
+func (f *valuefilterimpl) Predicate(expression string) interface{} {
+	if f.filterFn == nil {
+		return nil
+	}
+	return f.filterFn(expression)
+}